@@ -2,20 +2,20 @@ package main
 
 import (
 	"bufio"
-	"crypto/tls"
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
-	"time"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/0ranki/hydroxide-push/auth"
-	"github.com/0ranki/hydroxide-push/config"
 	"github.com/0ranki/hydroxide-push/events"
-	imapbackend "github.com/0ranki/hydroxide-push/imap"
 	"github.com/0ranki/hydroxide-push/ntfy"
 	"github.com/0ranki/hydroxide-push/protonmail"
-	imapserver "github.com/emersion/go-imap/server"
+	"github.com/0ranki/hydroxide-push/push"
 	"golang.org/x/term"
 )
 
@@ -58,20 +58,53 @@ func askPass(prompt string) ([]byte, error) {
 	return b, err
 }
 
-func listenEventsAndNotify(addr string, debug bool, authManager *auth.Manager, eventsManager *events.Manager, tlsConfig *tls.Config) {
-	be := imapbackend.New(authManager, eventsManager)
-	s := imapserver.New(be)
-	s.Addr = addr
-	s.AllowInsecureAuth = tlsConfig == nil
-	s.TLSConfig = tlsConfig
-	if debug {
-		s.Debug = os.Stdout
+func listenEventsAndNotify(authManager *auth.Manager) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	outbox, err := ntfy.OpenOutbox(&cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	outboxDone := make(chan struct{})
+	go func() {
+		outbox.Run(ctx)
+		close(outboxDone)
+	}()
+
+	history, err := ntfy.OpenHistory()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err := ntfy.LoadVAPIDKey(); err != nil {
+		log.Printf("cannot load VAPID key, Web Push is disabled: %v", err)
+	}
+
+	eventsManager := events.NewManager(ctx)
+	d := ntfy.Watch(&cfg, authManager, eventsManager)
+	log.Println("Listening for events")
+
+	<-ctx.Done()
+	stop()
+	log.Println("Shutting down")
+
+	status := 0
+	if err := d.Shutdown(); err != nil {
+		log.Println(err)
+		status = 1
 	}
-	ntfy.Login(&cfg, be)
-	log.Println("Listening for events", s.Addr)
-	for {
-		time.Sleep(10 * time.Second)
+	<-outboxDone
+	push.Close()
+	if err := outbox.Close(); err != nil {
+		log.Printf("cannot close outbox: %v", err)
+		status = 1
 	}
+	if err := history.Close(); err != nil {
+		log.Printf("cannot close notification history: %v", err)
+		status = 1
+	}
+	log.Println("Stopped")
+	os.Exit(status)
 }
 
 func authenticate(authCmd *flag.FlagSet) {
@@ -175,8 +208,71 @@ func authenticate(authCmd *flag.FlagSet) {
 	if err != nil {
 		log.Fatal(err)
 	}
-	cfg.BridgePw = bridgePassword
-	cfg.Setup()
+	cfg.Account(username).BridgePw = bridgePassword
+	if gotifyFromEnv() {
+		cfg.SetupGotify("")
+	} else {
+		cfg.Setup("")
+	}
+	if len(cfg.Accounts) > 1 {
+		fmt.Printf("Run setup-ntfy %s or setup-gotify %s to use a separate push endpoint for this account\n", username, username)
+	}
+}
+
+// gotifyFromEnv reports whether the Gotify endpoint is set in the environment
+func gotifyFromEnv() bool {
+	return os.Getenv("GOTIFY_URL") != "" && os.Getenv("GOTIFY_TOKEN") != ""
+}
+
+func testRules(args []string) {
+	sample := ntfy.SampleMessage("")
+	rulesCmd := flag.NewFlagSet("rules test", flag.ExitOnError)
+	account := rulesCmd.String("account", sample.Account, "Account receiving the message")
+	from := rulesCmd.String("from", sample.Sender.Address, "Sender address")
+	to := rulesCmd.String("to", "", "Proton address the message is received at (default: the account)")
+	subject := rulesCmd.String("subject", sample.Subject, "Subject")
+	labels := rulesCmd.String("labels", strings.Join(sample.Labels, ","), "Comma-separated label and folder names")
+	spamScore := rulesCmd.Int("spam-score", sample.SpamScore, "Spam score")
+	attachments := rulesCmd.Int("attachments", sample.Attachments, "Number of attachments")
+	starred := rulesCmd.Bool("starred", sample.Starred, "Whether the message is starred")
+	rulesCmd.Parse(args)
+
+	m := ntfy.SampleMessage(*account)
+	m.Sender.Address = *from
+	if *to != "" {
+		m.Address = *to
+	}
+	m.Subject = *subject
+	m.Labels = strings.Split(*labels, ",")
+	m.SpamScore = *spamScore
+	m.Attachments = *attachments
+	m.Starred = *starred
+
+	rule, i, errs := cfg.MatchRule(m)
+	for _, err := range errs {
+		fmt.Println(err)
+	}
+	if rule == nil {
+		fmt.Println("No rule matches the message")
+		return
+	}
+	fmt.Printf("Rule #%d %v matches the message\n", i+1, rule)
+	if rule.Suppress {
+		fmt.Println("Notification:  suppressed")
+		return
+	}
+	if rule.Priority != "" {
+		fmt.Printf("Priority:      %s\n", rule.Priority)
+	}
+	if len(rule.Tags) > 0 {
+		fmt.Printf("Added tags:    %s\n", strings.Join(rule.Tags, ","))
+	}
+	if rule.Topic != "" {
+		fmt.Printf("Topic:         %s\n", rule.Topic)
+	}
+	if len(rule.Sinks) > 0 {
+		fmt.Printf("Sinks:         %s\n", strings.Join(rule.Sinks, " "))
+	}
 }
 
 const usage = `usage: hydroxide-push [options...] <command>
@@ -184,7 +280,11 @@ Commands:
 	auth <username>		Login to ProtonMail via hydroxide
 	status				View hydroxide status
 	notify				Start the notification daemon
-	setup-ntfy          (Re)configure the push endpoint
+	setup-ntfy [username]	(Re)configure the push endpoint, optionally only for one account
+	setup-gotify [username]	(Re)configure a Gotify server as the push endpoint
+	webpush-key		Show the public key of Web Push requests
+	render-template [username]	Preview the notification sent for a sample message
+	rules test [options...]	Show which rule a sample message matches, see rules test -h
 
 Global options:
 	-debug
@@ -196,16 +296,22 @@ Global options:
 
 Environment variables:
 	HYDROXIDE_BRIDGE_PASS	Don't prompt for the bridge password, use this variable instead
+	PUSH_URL, PUSH_TOPIC, PUSH_USER, PUSH_PASSWORD	Configure the ntfy endpoint without prompting
+	GOTIFY_URL, GOTIFY_TOKEN	Configure the Gotify endpoint without prompting
+	PUSH_PRIVACY	Notification privacy level, one of generic, sender or subject
 `
 
 func main() {
 	flag.BoolVar(&debug, "debug", false, "Enable debug logs")
 	flag.StringVar(&apiEndpoint, "api-endpoint", defaultAPIEndpoint, "ProtonMail API endpoint")
 	flag.StringVar(&appVersion, "app-version", defaultAppVersion, "ProtonMail app version")
-
-	tlsCert := flag.String("tls-cert", "", "Path to the certificate to use for incoming connections")
-	tlsCertKey := flag.String("tls-key", "", "Path to the certificate key to use for incoming connections")
-	tlsClientCA := flag.String("tls-client-ca", "", "If set, clients must provide a certificate signed by the given CA")
+	// The IMAP listener these configured is gone, the flags are kept so that
+	// existing command lines keep working
+	deprecatedFlags := map[string]*string{
+		"tls-cert":      flag.String("tls-cert", "", "Deprecated, ignored"),
+		"tls-key":       flag.String("tls-key", "", "Deprecated, ignored"),
+		"tls-client-ca": flag.String("tls-client-ca", "", "Deprecated, ignored"),
+	}
 
 	authCmd := flag.NewFlagSet("auth", flag.ExitOnError)
 
@@ -214,13 +320,13 @@ func main() {
 	}
 
 	flag.Parse()
-
-	tlsConfig, err := config.TLS(*tlsCert, *tlsCertKey, *tlsClientCA)
-	if err != nil {
-		log.Fatal(err)
+	for name, value := range deprecatedFlags {
+		if *value != "" {
+			log.Printf("-%s is deprecated and ignored, no listener uses TLS anymore", name)
+		}
 	}
 
-	err = cfg.Read()
+	err := cfg.Read()
 	if err != nil {
 		fmt.Println(err)
 	}
@@ -247,19 +353,49 @@ func main() {
 		}
 
 	case "setup-ntfy":
-		cfg.Setup()
+		cfg.Setup(flag.Arg(1))
+
+	case "setup-gotify":
+		cfg.SetupGotify(flag.Arg(1))
+
+	case "webpush-key":
+		key, err := ntfy.LoadVAPIDKey()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(key)
+
+	case "render-template":
+		n, err := cfg.RenderSample(flag.Arg(1))
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Title:    %s\n", n.Title)
+		fmt.Printf("Body:     %s\n", n.Body)
+		fmt.Printf("Tags:     %s\n", strings.Join(n.Tags, ","))
+		fmt.Printf("Priority: %d\n", n.Priority)
+		fmt.Printf("Click:    %s\n", n.Click)
+
+	case "rules":
+		if flag.Arg(1) != "test" {
+			fmt.Print(usage)
+			log.Fatal("Unrecognized rules command")
+		}
+		testRules(flag.Args()[2:])
 
 	case "notify":
-		if os.Getenv("PROTON_ACCT_PASSWORD") != "" && os.Getenv("PROTON_ACCT") != "" && os.Getenv("PUSH_URL") != "" && os.Getenv("PUSH_TOPIC") != "" && cfg.BridgePw == "" {
+		pushFromEnv := os.Getenv("PUSH_URL") != "" && os.Getenv("PUSH_TOPIC") != ""
+		if os.Getenv("PROTON_ACCT_PASSWORD") != "" && os.Getenv("PROTON_ACCT") != "" && (pushFromEnv || gotifyFromEnv()) && cfg.BridgePassword(os.Getenv("PROTON_ACCT")) == "" {
 			log.Println("Logging in to Proton account using values from environment")
-			cfg.URL = os.Getenv("PUSH_URL")
-			cfg.Topic = os.Getenv("PUSH_TOPIC")
-			cfg.Save()
+			if pushFromEnv {
+				cfg.URL = os.Getenv("PUSH_URL")
+				cfg.Topic = os.Getenv("PUSH_TOPIC")
+				cfg.Save()
+			}
 			authenticate(new(flag.FlagSet))
 		}
 		authManager := auth.NewManager(newClient)
-		eventsManager := events.NewManager()
-		listenEventsAndNotify("0", debug, authManager, eventsManager, tlsConfig)
+		listenEventsAndNotify(authManager)
 
 	default:
 		fmt.Print(usage)
@@ -0,0 +1,84 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/0ranki/hydroxide-push/config"
+)
+
+// cursor is the last event processed for an account. It is persisted so that
+// events which happened while the daemon was not running can be replayed.
+type cursor struct {
+	EventID string
+	Time    int64
+}
+
+var cursorsLocker sync.Mutex
+
+func cursorsFilePath() (string, error) {
+	return config.Path("events.json")
+}
+
+func readCursors() (map[string]*cursor, error) {
+	p, err := cursorsFilePath()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get event cursor file path: %v", err)
+	}
+
+	b, err := os.ReadFile(p)
+	if os.IsNotExist(err) {
+		return make(map[string]*cursor), nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read event cursor file: %v", err)
+	}
+
+	cursors := make(map[string]*cursor)
+	if err := json.Unmarshal(b, &cursors); err != nil {
+		return nil, fmt.Errorf("failed to parse event cursor file: %v", err)
+	}
+	return cursors, nil
+}
+
+func loadCursor(username string) (*cursor, error) {
+	cursorsLocker.Lock()
+	defer cursorsLocker.Unlock()
+
+	cursors, err := readCursors()
+	if err != nil {
+		return nil, err
+	}
+	return cursors[username], nil
+}
+
+func saveCursor(username, eventID string) error {
+	cursorsLocker.Lock()
+	defer cursorsLocker.Unlock()
+
+	cursors, err := readCursors()
+	if err != nil {
+		return err
+	}
+	cursors[username] = &cursor{
+		EventID: eventID,
+		Time:    time.Now().Unix(),
+	}
+
+	b, err := json.Marshal(cursors)
+	if err != nil {
+		return err
+	}
+	p, err := cursorsFilePath()
+	if err != nil {
+		return fmt.Errorf("failed to get event cursor file path: %v", err)
+	}
+	// Write to a temporary file first so a crash never leaves a truncated cursor
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return fmt.Errorf("failed to write event cursor file: %v", err)
+	}
+	return os.Rename(tmp, p)
+}
@@ -1,6 +1,7 @@
 package events
 
 import (
+	"context"
 	"log"
 	"os"
 	"sync"
@@ -11,8 +12,19 @@ import (
 
 const pollInterval = 10 * time.Second
 
+// backfillLimit is the maximum number of unread Inbox messages replayed when
+// the event cursor is rejected by the API.
+const backfillLimit = 50
+
+// maxEventPages caps the number of event pages fetched back-to-back when the
+// API reports more events are pending, so a huge backlog cannot starve the
+// poll interval forever.
+const maxEventPages = 50
+
 type Receiver struct {
-	c *protonmail.Client
+	ctx      context.Context
+	c        *protonmail.Client
+	username string
 
 	locker   sync.Mutex
 	channels []chan<- *protonmail.Event
@@ -36,17 +48,49 @@ func (r *Receiver) receiveEvents() {
 	defer t.Stop()
 
 	var last string
+	var since time.Time
+	if cur, err := loadCursor(r.username); err != nil {
+		log.Printf("cannot load event cursor for %s: %v", r.username, err)
+	} else if cur != nil {
+		last = cur.EventID
+		since = time.Unix(cur.Time, 0)
+		log.Printf("resuming events for %s from %s", r.username, last)
+	}
+
+	pages := 0
 	for {
+		if r.ctx.Err() != nil {
+			log.Printf("stopped receiving events for %s", r.username)
+			return
+		}
+
 		event, err := r.c.GetEvent(last)
+		if isInvalidCursor(err) {
+			log.Printf("event cursor for %s rejected: %v", r.username, err)
+			log.Printf("backfilling unread Inbox messages received since %v", since)
+			event, err = r.backfill(since)
+		} else if err == nil && event.Refresh&protonmail.EventRefreshMail != 0 && !since.IsZero() {
+			log.Printf("mail refresh requested for %s", r.username)
+			log.Printf("backfilling unread Inbox messages received since %v", since)
+			var refresh *protonmail.Event
+			if refresh, err = r.backfill(since); err == nil {
+				refresh.Refresh = event.Refresh
+				event = refresh
+			}
+		}
 		if err != nil {
 			log.Println("cannot receive event:", err)
+			pages = 0
 			select {
 			case <-t.C:
 			case <-r.poll:
+			case <-r.ctx.Done():
 			}
 			continue
 		}
+		changed := event.ID != last
 		last = event.ID
+		since = time.Now()
 
 		r.locker.Lock()
 		n := len(r.channels)
@@ -59,28 +103,109 @@ func (r *Receiver) receiveEvents() {
 			break
 		}
 
+		// Only write the cursor when it moves, its time is the backfill
+		// cutoff after a restart and no message can arrive without a new
+		// event
+		if changed {
+			if err := saveCursor(r.username, last); err != nil {
+				log.Printf("cannot save event cursor for %s: %v", r.username, err)
+			}
+		}
+
+		// Drain the backlog before waiting for the next poll
+		pages++
+		if event.More != 0 {
+			if pages < maxEventPages {
+				continue
+			}
+			log.Printf("fetched %d event pages for %s, more pending after the next poll", pages, r.username)
+		} else if pages > 1 {
+			log.Printf("fetched %d event pages for %s", pages, r.username)
+		}
+		pages = 0
+
 		select {
 		case <-t.C:
 		case <-r.poll:
+		case <-r.ctx.Done():
 		}
 	}
 }
 
+// isInvalidCursor reports whether err is returned by the API because the
+// event ID is invalid or too old. Other errors are transient.
+func isInvalidCursor(err error) bool {
+	apiErr, ok := err.(*protonmail.APIError)
+	if !ok {
+		return false
+	}
+	switch apiErr.Code {
+	case protonmail.ErrCodeInvalidID, protonmail.ErrCodeDoesNotExist:
+		return true
+	}
+	return false
+}
+
+// backfill builds a synthetic event creating the unread Inbox messages
+// received after since, positioned at the latest event. It is used when the
+// event cursor is rejected by the API, and when the API asks to refresh the
+// mail.
+func (r *Receiver) backfill(since time.Time) (*protonmail.Event, error) {
+	latest, err := r.c.GetEvent("")
+	if err != nil {
+		return nil, err
+	}
+
+	unread := true
+	_, messages, err := r.c.ListMessages(&protonmail.MessageFilter{
+		PageSize: backfillLimit,
+		Label:    protonmail.LabelInbox,
+		Unread:   &unread,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	event := &protonmail.Event{ID: latest.ID}
+	for _, msg := range messages {
+		if msg.Time.Time().Before(since) {
+			continue
+		}
+		event.Messages = append(event.Messages, &protonmail.EventMessage{
+			ID:      msg.ID,
+			Action:  protonmail.EventCreate,
+			Created: msg,
+		})
+	}
+	log.Printf("backfilled %d unread Inbox message(s) for %s", len(event.Messages), r.username)
+	return event, nil
+}
+
 func (r *Receiver) Poll() {
 	r.poll <- struct{}{}
 }
 
 type Manager struct {
+	ctx       context.Context
 	receivers map[string]*Receiver
 	locker    sync.Mutex
+	wg        sync.WaitGroup
 }
 
-func NewManager() *Manager {
+// NewManager creates a new events manager. Receivers stop polling for events
+// when ctx is cancelled.
+func NewManager(ctx context.Context) *Manager {
 	return &Manager{
+		ctx:       ctx,
 		receivers: make(map[string]*Receiver),
 	}
 }
 
+// Wait waits for all receivers to stop
+func (m *Manager) Wait() {
+	m.wg.Wait()
+}
+
 func (m *Manager) Register(c *protonmail.Client, username string, ch chan<- *protonmail.Event, done <-chan struct{}) *Receiver {
 	m.locker.Lock()
 	defer m.locker.Unlock()
@@ -92,12 +217,16 @@ func (m *Manager) Register(c *protonmail.Client, username string, ch chan<- *pro
 		r.locker.Unlock()
 	} else {
 		r = &Receiver{
+			ctx:      m.ctx,
 			c:        c,
+			username: username,
 			channels: []chan<- *protonmail.Event{ch},
 			poll:     make(chan struct{}),
 		}
 
+		m.wg.Add(1)
 		go func() {
+			defer m.wg.Done()
 			r.receiveEvents()
 
 			m.locker.Lock()
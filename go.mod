@@ -5,6 +5,7 @@ go 1.22
 require (
 	github.com/ProtonMail/go-crypto v1.0.0
 	github.com/boltdb/bolt v1.3.1
+	github.com/eclipse/paho.mqtt.golang v1.4.3
 	github.com/emersion/go-bcrypt v0.0.0-20170822072041-6e724a1baa63
 	github.com/emersion/go-imap v1.2.1
 	github.com/emersion/go-mbox v1.0.3
@@ -13,12 +14,16 @@ require (
 	github.com/emersion/go-smtp v0.21.1
 	github.com/emersion/go-vcard v0.0.0-20230815062825-8fda7d206ec9
 	github.com/emersion/go-webdav v0.5.0
+	github.com/godbus/dbus/v5 v5.1.0
 	golang.org/x/crypto v0.22.0
 	golang.org/x/term v0.19.0
 )
 
 require (
 	github.com/cloudflare/circl v1.3.7 // indirect
+	github.com/gorilla/websocket v1.5.0 // indirect
+	golang.org/x/net v0.21.0 // indirect
+	golang.org/x/sync v0.1.0 // indirect
 	golang.org/x/sys v0.19.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
 )
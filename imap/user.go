@@ -1,7 +1,6 @@
 package imap
 
 import (
-	"github.com/0ranki/hydroxide-push/ntfy"
 	"log"
 	"strings"
 	"sync"
@@ -348,8 +347,6 @@ func (u *user) receiveEvents(updates chan<- imapbackend.Update, events <-chan *p
 							eventUpdates = append(eventUpdates, update)
 						}
 					}
-					// Send push notification to topic
-					go ntfy.Notify()
 				case protonmail.EventUpdate, protonmail.EventUpdateFlags:
 					log.Println("Received update event for message", eventMessage.ID)
 					//		createdSeqNums, deletedSeqNums, err := u.db.UpdateMessage(eventMessage.ID, eventMessage.Updated)
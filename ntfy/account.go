@@ -0,0 +1,120 @@
+package ntfy
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/0ranki/hydroxide-push/protonmail"
+)
+
+// labelsRefreshInterval is the minimum time between two refreshes of the
+// labels of an account, when a message has a label not seen before
+const labelsRefreshInterval = time.Minute
+
+var systemLabels = map[string]string{
+	protonmail.LabelInbox:    "Inbox",
+	protonmail.LabelAllDraft: "All Drafts",
+	protonmail.LabelAllSent:  "All Sent",
+	protonmail.LabelTrash:    "Trash",
+	protonmail.LabelSpam:     "Spam",
+	protonmail.LabelAllMail:  "All Mail",
+	protonmail.LabelArchive:  "Archive",
+	protonmail.LabelSent:     "Sent",
+	protonmail.LabelDraft:    "Drafts",
+	protonmail.LabelStarred:  "Starred",
+}
+
+// accountInfo caches the labels and addresses of an account
+type accountInfo struct {
+	c *protonmail.Client
+
+	sync.Mutex // protects everything below
+
+	labels    map[string]*protonmail.Label // indexed by label ID
+	addrs     map[string]string            // indexed by address ID
+	refreshed time.Time
+}
+
+var accountInfos = struct {
+	sync.Mutex
+	m map[string]*accountInfo
+}{m: make(map[string]*accountInfo)}
+
+// getAccountInfo returns the cached information of account, fetched using c
+func getAccountInfo(c *protonmail.Client, account string) *accountInfo {
+	accountInfos.Lock()
+	defer accountInfos.Unlock()
+
+	info, ok := accountInfos.m[account]
+	if !ok || info.c != c {
+		info = &accountInfo{c: c}
+		accountInfos.m[account] = info
+	}
+	return info
+}
+
+func (info *accountInfo) refresh() {
+	if time.Since(info.refreshed) < labelsRefreshInterval {
+		return
+	}
+	info.refreshed = time.Now()
+
+	labels, err := info.c.ListLabels()
+	if err != nil {
+		log.Printf("cannot list labels: %v", err)
+	} else {
+		info.labels = make(map[string]*protonmail.Label, len(labels))
+		for _, label := range labels {
+			info.labels[label.ID] = label
+		}
+	}
+
+	addrs, err := info.c.ListAddresses()
+	if err != nil {
+		log.Printf("cannot list addresses: %v", err)
+	} else {
+		info.addrs = make(map[string]string, len(addrs))
+		for _, addr := range addrs {
+			info.addrs[addr.ID] = addr.Email
+		}
+	}
+}
+
+// label returns the label with the given ID, or nil if it is a system label
+// or unknown
+func (info *accountInfo) label(id string) *protonmail.Label {
+	if _, ok := systemLabels[id]; ok {
+		return nil
+	}
+
+	info.Lock()
+	defer info.Unlock()
+
+	if _, ok := info.labels[id]; !ok {
+		info.refresh()
+	}
+	return info.labels[id]
+}
+
+// labelName returns the name of the label with the given ID
+func (info *accountInfo) labelName(id string) string {
+	if name, ok := systemLabels[id]; ok {
+		return name
+	}
+	if label := info.label(id); label != nil {
+		return label.Name
+	}
+	return id
+}
+
+// address returns the email address with the given ID
+func (info *accountInfo) address(id string) string {
+	info.Lock()
+	defer info.Unlock()
+
+	if _, ok := info.addrs[id]; !ok {
+		info.refresh()
+	}
+	return info.addrs[id]
+}
@@ -0,0 +1,336 @@
+package ntfy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+
+	"github.com/0ranki/hydroxide-push/config"
+	"github.com/0ranki/hydroxide-push/protonmail"
+	"github.com/0ranki/hydroxide-push/push"
+)
+
+// actionTokenMaxAge is the duration action buttons can be used after the
+// notification was sent
+const actionTokenMaxAge = 7 * 24 * time.Hour
+
+// maxActions is the maximum number of buttons supported by ntfy
+const maxActions = 3
+
+var (
+	tokensBucket = []byte("tokens")
+	groupsBucket = []byte("groups")
+)
+
+// ActionsConfig enables action buttons on notifications. Buttons call back
+// an HTTP endpoint served by the daemon, which performs the action on the
+// message.
+type ActionsConfig struct {
+	// Listen is the address the HTTP endpoint listens on, e.g. ":8080"
+	Listen string `json:"listen"`
+	// BaseURL is the URL the endpoint is reachable at from the devices
+	// receiving notifications
+	BaseURL string `json:"baseUrl"`
+	// Buttons are the actions shown on notifications, among read, archive,
+	// trash and spam. Defaults to read, archive and trash.
+	Buttons []string `json:"buttons,omitempty"`
+}
+
+var messageActions = map[string]struct {
+	label string
+	done  string
+	do    func(c *protonmail.Client, id string) error
+}{
+	"read": {"Mark read", "Message marked as read", func(c *protonmail.Client, id string) error {
+		return c.MarkMessagesRead([]string{id})
+	}},
+	"archive": {"Archive", "Message archived", func(c *protonmail.Client, id string) error {
+		return c.LabelMessages(protonmail.LabelArchive, []string{id})
+	}},
+	"trash": {"Trash", "Message moved to Trash", func(c *protonmail.Client, id string) error {
+		return c.LabelMessages(protonmail.LabelTrash, []string{id})
+	}},
+	"spam": {"Spam", "Message marked as spam", func(c *protonmail.Client, id string) error {
+		return c.LabelMessages(protonmail.LabelSpam, []string{id})
+	}},
+}
+
+var defaultButtons = []string{"read", "archive", "trash"}
+
+// actionToken is what an action button does. Button URLs only carry a
+// random ID, the tokens are kept in actions.db. Buttons of the same
+// notification share a group, so only one of them can be used.
+type actionToken struct {
+	Account   string `json:"account"`
+	MessageID string `json:"messageId"`
+	Action    string `json:"action"`
+	Group     string `json:"group"`
+	Expires   int64  `json:"expires"`
+}
+
+var errInvalidToken = errors.New("invalid or expired action token")
+
+// actionServer serves the endpoint called by action buttons
+type actionServer struct {
+	cfg *ActionsConfig
+	db  *bolt.DB
+	d   *Daemon
+	srv *http.Server
+}
+
+// randomID returns a random URL-safe string
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func startActions(cfg *ActionsConfig, d *Daemon) (*actionServer, error) {
+	if cfg.Listen == "" || cfg.BaseURL == "" {
+		return nil, errors.New("both listen and baseUrl must be set for action buttons")
+	}
+	for _, button := range cfg.Buttons {
+		if _, ok := messageActions[button]; !ok {
+			return nil, fmt.Errorf("unknown action button %q", button)
+		}
+	}
+
+	p, err := config.Path("actions.db")
+	if err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(p, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(tokensBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(groupsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s := &actionServer{cfg: cfg, db: db, d: d}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/action/", s.handleAction)
+	s.srv = &http.Server{
+		Addr:              cfg.Listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := s.srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("action endpoint failed: %v", err)
+		}
+	}()
+	log.Printf("Serving notification actions on %s", cfg.Listen)
+	return s, nil
+}
+
+func (s *actionServer) close(ctx context.Context) error {
+	err := s.srv.Shutdown(ctx)
+	if dbErr := s.db.Close(); err == nil {
+		err = dbErr
+	}
+	return err
+}
+
+// prune removes the expired tokens and groups
+func prune(tx *bolt.Tx) error {
+	now := time.Now().Unix()
+	var expired [][]byte
+	b := tx.Bucket(tokensBucket)
+	b.ForEach(func(k, v []byte) error {
+		var t actionToken
+		if err := json.Unmarshal(v, &t); err != nil || t.Expires < now {
+			expired = append(expired, k)
+		}
+		return nil
+	})
+	for _, k := range expired {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+
+	expired = nil
+	b = tx.Bucket(groupsBucket)
+	b.ForEach(func(k, v []byte) error {
+		if len(v) != 8 || int64(binary.BigEndian.Uint64(v)) < now {
+			expired = append(expired, k)
+		}
+		return nil
+	})
+	for _, k := range expired {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putGroup marks the buttons of group as usable until expires
+func putGroup(tx *bolt.Tx, group string, expires int64) error {
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, uint64(expires))
+	return tx.Bucket(groupsBucket).Put([]byte(group), v)
+}
+
+// addButtons adds action buttons to n
+func (s *actionServer) addButtons(n *push.Notification) error {
+	if n.Message == nil {
+		return nil
+	}
+
+	buttons := s.cfg.Buttons
+	if len(buttons) == 0 {
+		buttons = defaultButtons
+	}
+	if len(buttons) > maxActions {
+		buttons = buttons[:maxActions]
+	}
+
+	group, err := randomID()
+	if err != nil {
+		return err
+	}
+	expires := time.Now().Add(actionTokenMaxAge).Unix()
+	tokens := make(map[string]*actionToken)
+	var actions []push.Action
+	for _, button := range buttons {
+		id, err := randomID()
+		if err != nil {
+			return err
+		}
+		tokens[id] = &actionToken{
+			Account:   n.Account,
+			MessageID: n.Message.ID,
+			Action:    button,
+			Group:     group,
+			Expires:   expires,
+		}
+		actions = append(actions, push.Action{
+			Label: messageActions[button].label,
+			URL:   strings.TrimSuffix(s.cfg.BaseURL, "/") + "/action/" + id,
+		})
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		if err := prune(tx); err != nil {
+			return err
+		}
+		b := tx.Bucket(tokensBucket)
+		for id, t := range tokens {
+			v, err := json.Marshal(t)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(id), v); err != nil {
+				return err
+			}
+		}
+		return putGroup(tx, group, expires)
+	})
+	if err != nil {
+		return err
+	}
+	n.Actions = append(n.Actions, actions...)
+	return nil
+}
+
+// lookup returns the token of id
+func (s *actionServer) lookup(id string) (*actionToken, error) {
+	t := new(actionToken)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(tokensBucket).Get([]byte(id))
+		if v == nil {
+			return errInvalidToken
+		}
+		if err := json.Unmarshal(v, t); err != nil {
+			return errInvalidToken
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().Unix() > t.Expires {
+		return nil, errInvalidToken
+	}
+	return t, nil
+}
+
+// consume marks the group of t as used, failing if it already was
+func (s *actionServer) consume(t *actionToken) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(groupsBucket)
+		if b.Get([]byte(t.Group)) == nil {
+			return errInvalidToken
+		}
+		return b.Delete([]byte(t.Group))
+	})
+}
+
+// restore marks the group of t as unused again
+func (s *actionServer) restore(t *actionToken) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putGroup(tx, t.Group, t.Expires)
+	})
+}
+
+func (s *actionServer) handleAction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	t, err := s.lookup(strings.TrimPrefix(r.URL.Path, "/action/"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	action, ok := messageActions[t.Action]
+	if !ok {
+		http.Error(w, errInvalidToken.Error(), http.StatusForbidden)
+		return
+	}
+	c := s.d.client(t.Account)
+	if c == nil {
+		http.Error(w, "account not logged in", http.StatusNotFound)
+		return
+	}
+	if err := s.consume(t); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	if err := action.do(c, t.MessageID); err != nil {
+		log.Printf("action %s on message %s of %s failed: %v", t.Action, t.MessageID, t.Account, err)
+		// Allow trying again
+		if err := s.restore(t); err != nil {
+			log.Printf("cannot restore action token: %v", err)
+		}
+		http.Error(w, "action failed", http.StatusBadGateway)
+		return
+	}
+	log.Printf("Action %s performed on message %s of %s", t.Action, t.MessageID, t.Account)
+	io.WriteString(w, action.done+"\n")
+}
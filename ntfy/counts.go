@@ -0,0 +1,110 @@
+package ntfy
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/0ranki/hydroxide-push/protonmail"
+	"github.com/0ranki/hydroxide-push/push"
+)
+
+// countsTimeout bounds the time spent publishing message counts, so an
+// unreachable broker does not delay the next counts for long
+const countsTimeout = 10 * time.Second
+
+// queueCounts schedules publishing counts without blocking the event loop.
+// Counts not published yet are replaced, unless fetching the current counts
+// is pending, as nil counts request.
+func (a *account) queueCounts(counts []*protonmail.MessageCount) {
+	a.countsLock.Lock()
+	if !a.countsPending || a.pendingCounts != nil {
+		a.pendingCounts = counts
+	}
+	a.countsPending = true
+	a.countsLock.Unlock()
+
+	select {
+	case a.countsReady <- struct{}{}:
+	default:
+	}
+}
+
+// publishQueuedCounts publishes the counts queued by queueCounts until the
+// account stops being watched
+func (a *account) publishQueuedCounts() {
+	defer a.d.pending.Done()
+	for {
+		select {
+		case <-a.countsReady:
+		case <-a.done:
+			return
+		}
+
+		a.countsLock.Lock()
+		counts, ok := a.pendingCounts, a.countsPending
+		a.pendingCounts, a.countsPending = nil, false
+		a.countsLock.Unlock()
+		if ok {
+			a.publishCounts(counts)
+		}
+	}
+}
+
+// publishCounts publishes the message counts of Inbox and custom folders to
+// the sinks of the account implementing push.Counter. If counts is nil, the
+// current counts are fetched.
+func (a *account) publishCounts(counts []*protonmail.MessageCount) {
+	cfg := NtfyConfig{}
+	if err := cfg.Read(); err != nil {
+		log.Printf("error reading configuration: %v\n", err)
+		return
+	}
+	sinks, err := cfg.PushSinks(a.username)
+	if err != nil {
+		log.Printf("error configuring push sinks for %s: %v\n", a.username, err)
+		return
+	}
+	var counters []*push.Sink
+	for _, s := range sinks {
+		if _, ok := s.Notifier.(push.Counter); ok {
+			counters = append(counters, s)
+		}
+	}
+	if len(counters) == 0 {
+		return
+	}
+
+	if counts == nil {
+		if counts, err = a.c.CountMessages(""); err != nil {
+			log.Printf("cannot count messages of %s: %v", a.username, err)
+			return
+		}
+	}
+	info := getAccountInfo(a.c, a.username)
+	var labelCounts []push.LabelCount
+	for _, count := range counts {
+		if count.LabelID != protonmail.LabelInbox {
+			if label := info.label(count.LabelID); label == nil || label.Exclusive == 0 {
+				continue
+			}
+		}
+		labelCounts = append(labelCounts, push.LabelCount{
+			LabelID: count.LabelID,
+			Label:   info.labelName(count.LabelID),
+			Total:   count.Total,
+			Unread:  count.Unread,
+		})
+	}
+	if len(labelCounts) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(a.d.pushCtx, countsTimeout)
+	defer cancel()
+	for _, s := range counters {
+		if err := s.Notifier.(push.Counter).PublishCounts(ctx, a.username, labelCounts); err != nil {
+			log.Printf("failed to publish message counts to %s for %s: %v", s.Name, a.username, err)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package ntfy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/0ranki/hydroxide-push/auth"
+	"github.com/0ranki/hydroxide-push/events"
+	"github.com/0ranki/hydroxide-push/protonmail"
+)
+
+// releaseInterval is the interval at which the end of quiet hours is
+// checked for, to send the summary of the held notifications
+const releaseInterval = time.Minute
+
+// shutdownTimeout is the time given to in-flight notifications to be
+// delivered when the daemon stops. Notifications still pending after that
+// are queued in the outbox.
+const shutdownTimeout = 15 * time.Second
+
+// Daemon notifies the new messages of the watched accounts
+type Daemon struct {
+	cfg           *NtfyConfig
+	eventsManager *events.Manager
+	// accounts are the watched accounts, locked as the action endpoint
+	// looks them up while they are logged in
+	accountsLock sync.Mutex
+	accounts     []*account
+	// actions is the action endpoint, nil if action buttons are disabled
+	actions *actionServer
+
+	// pushCtx is cancelled when in-flight notifications are given up on
+	pushCtx    context.Context
+	cancelPush context.CancelFunc
+	pending    sync.WaitGroup
+	// done is closed when the daemon stops
+	done chan struct{}
+}
+
+// account is a Proton account watched for new messages
+type account struct {
+	username string
+	c        *protonmail.Client
+	d        *Daemon
+
+	done chan struct{}
+
+	// Message counts waiting to be published, see queueCounts
+	countsLock    sync.Mutex
+	pendingCounts []*protonmail.MessageCount
+	countsPending bool
+	countsReady   chan struct{}
+}
+
+// Watch logs in all the accounts and notifies their new messages. Accounts
+// are authenticated directly against the cached credentials, without going
+// through a local IMAP backend.
+func Watch(cfg *NtfyConfig, sessions *auth.Manager, eventsManager *events.Manager) *Daemon {
+	usernames, err := auth.ListUsernames()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = cfg.Read()
+	if err != nil {
+		log.Println(err)
+	}
+	if len(usernames) == 0 || cfg.URL == "" || cfg.Topic == "" {
+		executable, _ := os.Executable()
+		log.Println("login first using " + executable + " auth <protonmail username>")
+		log.Fatalln("then setup ntfy using " + executable + " setup-ntfy")
+	}
+
+	d := &Daemon{
+		cfg:           cfg,
+		eventsManager: eventsManager,
+		done:          make(chan struct{}),
+	}
+	d.pushCtx, d.cancelPush = context.WithCancel(context.Background())
+
+	// Start the action endpoint first, so the buttons are added to the
+	// notifications of the messages received right after login
+	if cfg.Actions != nil {
+		d.actions, err = startActions(cfg.Actions, d)
+		if err != nil {
+			log.Printf("cannot enable action buttons: %v", err)
+		}
+	}
+
+	for _, username := range usernames {
+		if cfg.BridgePassword(username) == "" {
+			err = LoginBridge(cfg, username)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		c, _, err := sessions.Auth(username, cfg.BridgePassword(username))
+		if err != nil {
+			log.Printf("cannot login %s: %v", username, err)
+			continue
+		}
+
+		a := &account{
+			username: username,
+			c:        c,
+			d:        d,
+			done:     make(chan struct{}),
+
+			countsReady: make(chan struct{}, 1),
+		}
+		ch := make(chan *protonmail.Event)
+		d.pending.Add(2)
+		go a.receiveEvents(ch)
+		go a.publishQueuedCounts()
+		eventsManager.Register(c, username, ch, a.done)
+		d.accountsLock.Lock()
+		d.accounts = append(d.accounts, a)
+		d.accountsLock.Unlock()
+
+		log.Printf("Logged in as user %q", username)
+	}
+	if len(d.accounts) == 0 {
+		log.Fatal("no account could be logged in")
+	}
+	log.Printf("Watching %d of %d account(s)", len(d.accounts), len(usernames))
+
+	d.pending.Add(1)
+	go d.releaseHeld()
+	return d
+}
+
+// releaseHeld sends the summaries of the notifications held during quiet
+// hours when they end, including those held before a restart
+func (d *Daemon) releaseHeld() {
+	defer d.pending.Done()
+
+	ticker := time.NewTicker(releaseInterval)
+	defer ticker.Stop()
+	for {
+		for _, a := range d.accounts {
+			a.releaseHeld()
+		}
+		select {
+		case <-ticker.C:
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// client returns the client of a watched account, or nil
+func (d *Daemon) client(username string) *protonmail.Client {
+	d.accountsLock.Lock()
+	defer d.accountsLock.Unlock()
+	for _, a := range d.accounts {
+		if a.username == username {
+			return a.c
+		}
+	}
+	return nil
+}
+
+func (a *account) receiveEvents(events <-chan *protonmail.Event) {
+	defer a.d.pending.Done()
+
+	a.queueCounts(nil)
+	for event := range events {
+		if event.Refresh&protonmail.EventRefreshMail != 0 {
+			// The events receiver replaced the event with the recent
+			// unread messages
+			log.Printf("Mail refresh requested for %s, notifying %d recent unread message(s)", a.username, len(event.Messages))
+			a.queueCounts(nil)
+		} else if len(event.MessageCounts) > 0 {
+			a.queueCounts(event.MessageCounts)
+		}
+		for _, eventMessage := range event.Messages {
+			switch eventMessage.Action {
+			case protonmail.EventCreate:
+				log.Println("Received create event for message", eventMessage.ID)
+				a.d.pending.Add(1)
+				go func(eventID string, msg *protonmail.Message) {
+					defer a.d.pending.Done()
+					a.d.Notify(a.d.pushCtx, a.c, a.username, eventID, msg)
+				}(event.ID, eventMessage.Created)
+			case protonmail.EventUpdate, protonmail.EventUpdateFlags:
+				log.Println("Received update event for message", eventMessage.ID)
+				if del, ok := isWithdrawn(eventMessage.Updated); ok {
+					a.withdraw(eventMessage.ID, del)
+				}
+			case protonmail.EventDelete:
+				log.Println("Received delete event for message", eventMessage.ID)
+				a.withdraw(eventMessage.ID, true)
+			}
+		}
+	}
+}
+
+// isWithdrawn reports whether the notification of a message should be
+// withdrawn after update, and whether it should be deleted rather than
+// cleared
+func isWithdrawn(update *protonmail.EventMessageUpdate) (del, ok bool) {
+	for _, labelIDs := range [][]string{update.LabelIDs, update.LabelIDsAdded} {
+		for _, labelID := range labelIDs {
+			if labelID == protonmail.LabelTrash || labelID == protonmail.LabelSpam {
+				return true, true
+			}
+		}
+	}
+	if update.Unread != nil && *update.Unread == 0 {
+		return false, true
+	}
+	return false, false
+}
+
+// withdraw clears or deletes the notification sent for a message, or
+// forgets it if it is held during quiet hours
+func (a *account) withdraw(messageID string, del bool) {
+	unhold(a.username, messageID)
+	if a.d.cfg.KeepNotifications {
+		return
+	}
+	a.d.pending.Add(1)
+	go func() {
+		defer a.d.pending.Done()
+		history.Withdraw(a.d.pushCtx, a.username, messageID, del)
+	}()
+}
+
+// Shutdown stops watching the accounts and waits for in-flight
+// notifications. The events manager context must be cancelled first.
+func (d *Daemon) Shutdown() error {
+	for _, a := range d.accounts {
+		close(a.done)
+	}
+	close(d.done)
+	d.eventsManager.Wait()
+
+	var errs []error
+
+	pending := make(chan struct{})
+	go func() {
+		d.pending.Wait()
+		close(pending)
+	}()
+	select {
+	case <-pending:
+	case <-time.After(shutdownTimeout):
+		errs = append(errs, fmt.Errorf("notifications still pending after %v", shutdownTimeout))
+		// Abort in-flight requests, failed notifications are queued
+		d.cancelPush()
+		<-pending
+	}
+	d.cancelPush()
+
+	if d.actions != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		if err := d.actions.close(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("cannot stop action endpoint: %v", err))
+		}
+		cancel()
+	}
+
+	if d.cfg.LogoutOnExit {
+		for _, a := range d.accounts {
+			if err := a.c.Logout(); err != nil {
+				errs = append(errs, fmt.Errorf("cannot logout %s: %v", a.username, err))
+			} else {
+				log.Printf("Logged out %s", a.username)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,70 @@
+package ntfy
+
+import (
+	"strings"
+
+	"github.com/0ranki/hydroxide-push/protonmail"
+	"github.com/0ranki/hydroxide-push/push"
+)
+
+// silentLabels are the system labels whose messages are not notified by
+// default
+var silentLabels = []string{
+	protonmail.LabelSpam,
+	protonmail.LabelTrash,
+	protonmail.LabelAllSent,
+	protonmail.LabelSent,
+	protonmail.LabelAllDraft,
+	protonmail.LabelDraft,
+}
+
+// LabelFilter overrides which messages are notified based on the names of
+// their labels and folders. Messages with a denied label are never notified,
+// messages with an allowed label always are. Otherwise messages in Spam,
+// Trash, Sent and Drafts, and in folders with notifications disabled in
+// Proton, are not notified.
+type LabelFilter struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+}
+
+func containsLabel(list []string, name string) bool {
+	for _, s := range list {
+		if strings.EqualFold(s, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// shouldNotify reports whether msg should be notified. If not, the name of
+// the label suppressing the notification is returned.
+func (f *LabelFilter) shouldNotify(info *accountInfo, msg *protonmail.Message, m *push.Message) (bool, string) {
+	if f != nil {
+		for _, name := range m.Labels {
+			if containsLabel(f.Deny, name) {
+				return false, name
+			}
+		}
+		for _, name := range m.Labels {
+			if containsLabel(f.Allow, name) {
+				return true, ""
+			}
+		}
+	}
+
+	for i, labelID := range msg.LabelIDs {
+		for _, silent := range silentLabels {
+			if labelID == silent {
+				return false, m.Labels[i]
+			}
+		}
+		if info == nil {
+			continue
+		}
+		if label := info.label(labelID); label != nil && label.Exclusive == 1 && label.Notify == 0 {
+			return false, m.Labels[i]
+		}
+	}
+	return true, ""
+}
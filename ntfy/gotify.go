@@ -0,0 +1,126 @@
+package ntfy
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"golang.org/x/crypto/ssh/terminal"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// GotifyEndpoint is a Gotify application notifications are published to
+type GotifyEndpoint struct {
+	URL string `json:"url"`
+	// Token is the application token, Base64-encoded
+	Token string `json:"token"`
+}
+
+func (g *GotifyEndpoint) valid() bool {
+	return g != nil && g.URL != "" && g.Token != ""
+}
+
+// Target returns the endpoint as a push target, see push.New
+func (g *GotifyEndpoint) Target() (string, error) {
+	u, err := url.Parse(g.URL)
+	if err != nil {
+		return "", fmt.Errorf("invalid Gotify server URL: %v", err)
+	}
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "gotify"
+	case "https":
+		u.Scheme = "gotifys"
+	default:
+		return "", fmt.Errorf("unsupported Gotify server URL scheme %q", u.Scheme)
+	}
+	token, err := base64.StdEncoding.DecodeString(g.Token)
+	if err != nil {
+		return "", fmt.Errorf("error decoding Gotify token: %v", err)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/"
+	u.RawQuery = url.Values{"token": {string(token)}}.Encode()
+	return u.String(), nil
+}
+
+// SetupGotify configures the Gotify endpoint interactively or from the
+// environment. If username is set, the endpoint used only for that account
+// is configured.
+func (cfg *NtfyConfig) SetupGotify(username string) {
+	g := cfg.Gotify
+	if username != "" {
+		acct := cfg.Account(username)
+		if acct.Gotify == nil {
+			acct.Gotify = new(GotifyEndpoint)
+			if g != nil {
+				acct.Gotify.URL = g.URL
+			}
+		}
+		g = acct.Gotify
+		fmt.Printf("Configuring Gotify endpoint for %s\n", username)
+	} else if g == nil {
+		g = new(GotifyEndpoint)
+		cfg.Gotify = g
+	}
+
+	// Configure using environment
+	if os.Getenv("GOTIFY_URL") != "" && os.Getenv("GOTIFY_TOKEN") != "" {
+		g.URL = os.Getenv("GOTIFY_URL")
+		g.Token = base64.StdEncoding.EncodeToString([]byte(os.Getenv("GOTIFY_TOKEN")))
+		log.Printf("Current Gotify server: %s\n", g.URL)
+		if username == "" {
+			cfg.setupPrivacyFromEnv()
+		}
+		err := cfg.Save()
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	var n string
+	if g.valid() {
+		fmt.Printf("Current Gotify server: %s\n", g.URL)
+		fmt.Println("You'll need to input the application token again")
+		n = "new "
+	}
+
+	// Read Gotify base URL
+	scanner := bufio.NewScanner(os.Stdin)
+	for {
+		tmpURL := g.URL
+		fmt.Printf("Input %sGotify server URL ('%s') : ", n, g.URL)
+		scanner.Scan()
+		if len(scanner.Text()) > 0 {
+			tmpURL = scanner.Text()
+		}
+		if _, err := url.ParseRequestURI(tmpURL); err != nil {
+			fmt.Printf("Not a valid URL: %s\n", tmpURL)
+		} else {
+			g.URL = tmpURL
+			break
+		}
+	}
+	// Read application token
+	g.Token = ""
+	for g.Token == "" {
+		fmt.Printf("Application token: ")
+		tokenBytes, err := terminal.ReadPassword(0)
+		fmt.Println()
+		if err != nil {
+			fmt.Printf("Error reading token: %v\n", err)
+			return
+		}
+		if len(tokenBytes) > 0 {
+			// Store the token in base64 for a little obfuscation
+			g.Token = base64.StdEncoding.EncodeToString(tokenBytes)
+		}
+	}
+	fmt.Printf("Using Gotify server %s\n", g.URL)
+	if username == "" {
+		cfg.setupPrivacy(scanner)
+	}
+	cfg.saveSetup()
+}
@@ -0,0 +1,127 @@
+package ntfy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/0ranki/hydroxide-push/protonmail"
+	"github.com/0ranki/hydroxide-push/push"
+)
+
+// Privacy levels controlling how much of a message is shown in notifications
+const (
+	// PrivacyGeneric only tells a new message was received
+	PrivacyGeneric = "generic"
+	// PrivacySender shows the sender of the message
+	PrivacySender = "sender"
+	// PrivacySubject shows the sender and the subject of the message
+	PrivacySubject = "subject"
+)
+
+func validPrivacy(privacy string) bool {
+	switch privacy {
+	case PrivacyGeneric, PrivacySender, PrivacySubject:
+		return true
+	}
+	return false
+}
+
+func newAddress(addr *protonmail.MessageAddress) push.Address {
+	if addr == nil {
+		return push.Address{Address: "unknown sender"}
+	}
+	return push.Address{Name: addr.Name, Address: addr.Address}
+}
+
+// newMessage converts msg to the data model used by notifications. Label and
+// address IDs are resolved using info, if available.
+func newMessage(info *accountInfo, account string, msg *protonmail.Message) *push.Message {
+	m := &push.Message{
+		ID:          msg.ID,
+		Account:     account,
+		Sender:      newAddress(msg.Sender),
+		Subject:     msg.Subject,
+		AddressID:   msg.AddressID,
+		Time:        msg.Time.Time(),
+		Attachments: msg.NumAttachments,
+		SpamScore:   msg.SpamScore,
+	}
+	for _, addr := range msg.ToList {
+		m.Recipients = append(m.Recipients, newAddress(addr))
+	}
+	for _, labelID := range msg.LabelIDs {
+		if labelID == protonmail.LabelStarred {
+			m.Starred = true
+		}
+		name := labelID
+		if info != nil {
+			name = info.labelName(labelID)
+		} else if systemName, ok := systemLabels[labelID]; ok {
+			name = systemName
+		}
+		m.Labels = append(m.Labels, name)
+	}
+	if info != nil {
+		m.Address = info.address(msg.AddressID)
+	}
+	return m
+}
+
+// notificationID derives the ID identifying the notification of a message
+// in push services
+func notificationID(account, messageID string) string {
+	sum := sha256.Sum256([]byte(account + "/" + messageID))
+	return hex.EncodeToString(sum[:12])
+}
+
+// newNotification builds the notification for m, showing as much of the
+// message as allowed by privacy
+func newNotification(m *push.Message, privacy string) *push.Notification {
+	n := &push.Notification{
+		ID:        notificationID(m.Account, m.ID),
+		Account:   m.Account,
+		MessageID: m.ID,
+		Title:     "ProtonMail",
+		Body:      "New message received",
+		Tags:      []string{"envelope", m.Account},
+		Message:   m,
+	}
+
+	switch privacy {
+	case PrivacySender, PrivacySubject:
+		n.Title = m.Sender.String()
+		if privacy == PrivacySubject {
+			n.Body = m.Subject
+			if n.Body == "" {
+				n.Body = "(no subject)"
+			}
+		}
+		if m.Attachments > 0 {
+			n.Body += fmt.Sprintf(" (%d attachment(s))", m.Attachments)
+			n.Tags = append(n.Tags, "paperclip")
+		}
+	}
+	return n
+}
+
+// shownMessage returns the details of m allowed to be shown by privacy, for
+// sinks posting them along with the notification
+func shownMessage(m *push.Message, privacy string) *push.Message {
+	shown := &push.Message{
+		ID:        m.ID,
+		EventID:   m.EventID,
+		Account:   m.Account,
+		AddressID: m.AddressID,
+		Time:      m.Time,
+	}
+	switch privacy {
+	case PrivacySender, PrivacySubject:
+		shown.Sender = m.Sender
+		if privacy == PrivacySubject {
+			shown.Subject = m.Subject
+			shown.Labels = m.Labels
+		}
+	}
+	return shown
+}
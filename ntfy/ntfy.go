@@ -2,32 +2,180 @@ package ntfy
 
 import (
 	"bufio"
+	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"golang.org/x/crypto/ssh/terminal"
 	"log"
-	"net"
-	"net/http"
 	"net/url"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/0ranki/hydroxide-push/auth"
 	"github.com/0ranki/hydroxide-push/config"
-	"github.com/emersion/go-imap"
-	"github.com/emersion/go-imap/backend"
+	"github.com/0ranki/hydroxide-push/protonmail"
+	"github.com/0ranki/hydroxide-push/push"
 )
 
-type NtfyConfig struct {
+// Endpoint is an ntfy topic notifications are published to
+type Endpoint struct {
 	URL      string `json:"url"`
 	Topic    string `json:"topic"`
-	BridgePw string `json:"bridgePw"`
 	User     string `json:"user"`
 	Password string `json:"password"`
 }
 
+type NtfyConfig struct {
+	Endpoint
+	// BridgePw is the bridge password of the single account supported by
+	// older versions, used for accounts without their own password.
+	BridgePw string                    `json:"bridgePw"`
+	Accounts map[string]*AccountConfig `json:"accounts,omitempty"`
+	// Sinks lists push targets as URLs, see push.New. The endpoint
+	// above is used when the list is empty.
+	Sinks []string `json:"sinks,omitempty"`
+	// Privacy is the amount of message details shown in notifications,
+	// one of PrivacyGeneric (the default), PrivacySender or PrivacySubject
+	Privacy string `json:"privacy,omitempty"`
+	// Templates customize the notifications, see Templates
+	Templates *Templates `json:"templates,omitempty"`
+	// LabelFilter selects the messages notified by their labels
+	LabelFilter *LabelFilter `json:"labels,omitempty"`
+	// Rules are evaluated in order for each new message, the first
+	// matching rule applies
+	Rules []*Rule `json:"rules,omitempty"`
+	// OutboxMaxAge is the duration after which notifications which could
+	// not be delivered are dropped, 24h by default
+	OutboxMaxAge string `json:"outboxMaxAge,omitempty"`
+	// LogoutOnExit ends the Proton sessions when the daemon stops
+	LogoutOnExit bool `json:"logoutOnExit,omitempty"`
+	// KeepNotifications disables clearing notifications when their
+	// message is read, trashed or deleted
+	KeepNotifications bool `json:"keepNotifications,omitempty"`
+	// Actions enables action buttons on notifications
+	Actions *ActionsConfig `json:"actions,omitempty"`
+	// Gotify is used instead of the ntfy endpoint above when set
+	Gotify *GotifyEndpoint `json:"gotify,omitempty"`
+	// Telegram is a chat notifications are also sent to
+	Telegram *TelegramConfig `json:"telegram,omitempty"`
+	// PGPPublicKey is an armored OpenPGP public key, or the path of a file
+	// containing one, notification titles and bodies are encrypted to
+	PGPPublicKey string `json:"pgpPublicKey,omitempty"`
+	// QuietHours are the times notifications are dropped, sent with the
+	// minimum priority or held
+	QuietHours *QuietHours `json:"quietHours,omitempty"`
+}
+
+// AccountConfig holds the settings of a single Proton account, indexed
+// by username in NtfyConfig
+type AccountConfig struct {
+	BridgePw string `json:"bridgePw"`
+	// Push, Gotify and Sinks override the default push targets for this
+	// account
+	Push    *Endpoint       `json:"push,omitempty"`
+	Gotify  *GotifyEndpoint `json:"gotify,omitempty"`
+	Sinks   []string        `json:"sinks,omitempty"`
+	Privacy string          `json:"privacy,omitempty"`
+}
+
+// Account returns the configuration for username, creating it if necessary
+func (cfg *NtfyConfig) Account(username string) *AccountConfig {
+	if cfg.Accounts == nil {
+		cfg.Accounts = make(map[string]*AccountConfig)
+	}
+	acct, ok := cfg.Accounts[username]
+	if !ok {
+		acct = new(AccountConfig)
+		cfg.Accounts[username] = acct
+	}
+	return acct
+}
+
+// BridgePassword returns the bridge password used to login username
+func (cfg *NtfyConfig) BridgePassword(username string) string {
+	if acct, ok := cfg.Accounts[username]; ok && acct.BridgePw != "" {
+		return acct.BridgePw
+	}
+	return cfg.BridgePw
+}
+
+// PushEndpoint returns the push endpoint notifications for username are sent to
+func (cfg *NtfyConfig) PushEndpoint(username string) *Endpoint {
+	if acct, ok := cfg.Accounts[username]; ok && acct.Push != nil && acct.Push.URL != "" && acct.Push.Topic != "" {
+		return acct.Push
+	}
+	return &cfg.Endpoint
+}
+
+// PrivacyLevel returns the privacy level of notifications for username
+func (cfg *NtfyConfig) PrivacyLevel(username string) string {
+	privacy := cfg.Privacy
+	if acct, ok := cfg.Accounts[username]; ok && acct.Privacy != "" {
+		privacy = acct.Privacy
+	}
+	if !validPrivacy(privacy) {
+		return PrivacyGeneric
+	}
+	return privacy
+}
+
+// PushSinks returns the sinks notifications for username are sent to. The
+// Telegram chat is added, unless the account has its own list of sinks.
+func (cfg *NtfyConfig) PushSinks(username string) ([]*push.Sink, error) {
+	targets := cfg.Sinks
+	if acct, ok := cfg.Accounts[username]; ok {
+		if len(acct.Sinks) > 0 {
+			return newSinks(acct.Sinks)
+		} else if acct.Gotify.valid() || (acct.Push != nil && acct.Push.URL != "" && acct.Push.Topic != "") {
+			targets = nil
+		}
+	}
+	if len(targets) == 0 {
+		target, err := cfg.pushTarget(username)
+		if err != nil {
+			return nil, err
+		}
+		targets = []string{target}
+	}
+	if target := cfg.telegramTarget(); target != "" {
+		// Copy rather than append to cfg.Sinks
+		targets = append(targets[:len(targets):len(targets)], target)
+	}
+
+	return newSinks(targets)
+}
+
+// pushTarget returns the endpoint configured by setup-ntfy or setup-gotify
+// for username, preferring the account endpoints and Gotify
+func (cfg *NtfyConfig) pushTarget(username string) (string, error) {
+	acct := cfg.Accounts[username]
+	if acct != nil && acct.Gotify.valid() {
+		return acct.Gotify.Target()
+	}
+	if acct != nil && acct.Push != nil && acct.Push.URL != "" && acct.Push.Topic != "" {
+		return acct.Push.Target()
+	}
+	if cfg.Gotify.valid() {
+		return cfg.Gotify.Target()
+	}
+	return cfg.Endpoint.Target()
+}
+
+func newSinks(targets []string) ([]*push.Sink, error) {
+	sinks := make([]*push.Sink, 0, len(targets))
+	for _, target := range targets {
+		s, err := push.New(target)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	return sinks, nil
+}
+
 func (cfg *NtfyConfig) Init() {
 	if cfg.Topic == "" {
 		r := make([]byte, 12)
@@ -43,10 +191,35 @@ func (cfg *NtfyConfig) Init() {
 	}
 }
 
-func (cfg *NtfyConfig) URI() string {
+func (cfg *Endpoint) URI() string {
 	return fmt.Sprintf("%s/%s", cfg.URL, cfg.Topic)
 }
 
+// Target returns the endpoint as a push target, see push.New
+func (cfg *Endpoint) Target() (string, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return "", fmt.Errorf("invalid push server URL: %v", err)
+	}
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ntfy"
+	case "https":
+		u.Scheme = "ntfys"
+	default:
+		return "", fmt.Errorf("unsupported push server URL scheme %q", u.Scheme)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + cfg.Topic
+	if cfg.User != "" && cfg.Password != "" {
+		pw, err := base64.StdEncoding.DecodeString(cfg.Password)
+		if err != nil {
+			return "", fmt.Errorf("error decoding push endpoint password: %v", err)
+		}
+		u.User = url.UserPassword(cfg.User, string(pw))
+	}
+	return u.String(), nil
+}
+
 func (cfg *NtfyConfig) Save() error {
 	b, err := json.Marshal(cfg)
 	if err != nil {
@@ -63,30 +236,211 @@ func ntfyConfigFile() (string, error) {
 	return config.Path("notify.json")
 }
 
-func Notify() {
+const defaultOutboxMaxAge = 24 * time.Hour
+
+// outbox queues notifications which failed to be delivered, nil until
+// OpenOutbox is called
+var outbox *push.Outbox
+
+// OpenOutbox opens the outbox used to retry notifications which failed to
+// be delivered
+func OpenOutbox(cfg *NtfyConfig) (*push.Outbox, error) {
+	maxAge := defaultOutboxMaxAge
+	if cfg.OutboxMaxAge != "" {
+		var err error
+		maxAge, err = time.ParseDuration(cfg.OutboxMaxAge)
+		if err != nil {
+			return nil, fmt.Errorf("invalid outboxMaxAge: %v", err)
+		}
+	}
+	p, err := config.Path("outbox.db")
+	if err != nil {
+		return nil, err
+	}
+	o, err := push.OpenOutbox(p, maxAge)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open outbox: %v", err)
+	}
+	outbox = o
+	return o, nil
+}
+
+// historyMaxAge is the duration notifications can be cleared after
+const historyMaxAge = 7 * 24 * time.Hour
+
+// history remembers sent notifications, nil until OpenHistory is called
+var history *push.History
+
+// OpenHistory opens the history of sent notifications, used to clear them
+// when their message is read
+func OpenHistory() (*push.History, error) {
+	p, err := config.Path("history.db")
+	if err != nil {
+		return nil, err
+	}
+	h, err := push.OpenHistory(p, historyMaxAge)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open notification history: %v", err)
+	}
+	history = h
+	return h, nil
+}
+
+// LoadVAPIDKey loads the key signing Web Push requests, generating it if
+// needed, and returns its public key
+func LoadVAPIDKey() (string, error) {
+	p, err := config.Path("vapid.pem")
+	if err != nil {
+		return "", err
+	}
+	return push.LoadVAPIDKey(p)
+}
+
+// Notify publishes a notification about msg received by account in event
+// eventID to all its sinks. c is used to resolve the labels and address of
+// the message.
+func (d *Daemon) Notify(ctx context.Context, c *protonmail.Client, account, eventID string, msg *protonmail.Message) {
 	cfg := NtfyConfig{}
 	if err := cfg.Read(); err != nil {
 		log.Printf("error reading configuration: %v\n", err)
 		return
 	}
-	req, _ := http.NewRequest("POST", cfg.URI(), strings.NewReader("New message received"))
-	if cfg.User != "" && cfg.Password != "" {
-		pw, err := base64.StdEncoding.DecodeString(cfg.Password)
-		if err != nil {
-			log.Printf("Error decoding push endpoint password: %v\n", err)
+	sinks, err := cfg.PushSinks(account)
+	if err != nil {
+		log.Printf("error configuring push sinks for %s: %v\n", account, err)
+		return
+	}
+	info := getAccountInfo(c, account)
+	m := newMessage(info, account, msg)
+	m.EventID = eventID
+	if ok, label := cfg.LabelFilter.shouldNotify(info, msg, m); !ok {
+		log.Printf("Not notifying message %s of %s: labelled %s", msg.ID, account, label)
+		return
+	}
+	rule, _, errs := cfg.MatchRule(m)
+	for _, err := range errs {
+		log.Printf("error evaluating rules: %v\n", err)
+	}
+	if rule != nil && rule.Suppress {
+		log.Printf("Not notifying message %s of %s: suppressed by rule %v", msg.ID, account, rule)
+		return
+	}
+	n := newNotification(m, cfg.PrivacyLevel(account))
+	if err := cfg.Templates.Apply(n); err != nil {
+		log.Printf("error applying notification templates: %v\n", err)
+	}
+	if d.actions != nil {
+		if err := d.actions.addButtons(n); err != nil {
+			log.Printf("error adding action buttons: %v\n", err)
+		}
+	}
+	if rule != nil {
+		log.Printf("Message %s of %s matches rule %v", msg.ID, account, rule)
+		if err := rule.apply(n); err != nil {
+			log.Printf("error applying rule %v: %v\n", rule, err)
+		}
+		if len(rule.Sinks) > 0 {
+			if sinks, err = newSinks(rule.Sinks); err != nil {
+				log.Printf("error configuring push sinks of rule %v: %v\n", rule, err)
+				return
+			}
+		}
+	}
+	mode, err := cfg.QuietHours.mode(m, time.Now())
+	if err != nil {
+		log.Printf("error evaluating quiet hours: %v\n", err)
+	}
+	switch mode {
+	case QuietDrop:
+		log.Printf("Not notifying message %s of %s: quiet hours", msg.ID, account)
+		return
+	case QuietMin:
+		n.Priority = 1
+	case QuietHold:
+		// Automations still get the message right away
+		var now, later []*push.Sink
+		for _, s := range sinks {
+			if isAutomation(s) {
+				now = append(now, s)
+			} else {
+				later = append(later, s)
+			}
+		}
+		if len(later) == 0 {
+			break
+		}
+		if err := hold(n, later, cfg.PGPPublicKey != ""); err != nil {
+			log.Printf("cannot hold notification during quiet hours: %v", err)
+			break
+		}
+		log.Printf("Holding notification of message %s of %s until quiet hours end", msg.ID, account)
+		if len(now) == 0 {
 			return
 		}
-		req.SetBasicAuth(cfg.User, string(pw))
+		sinks = now
+	}
+	if err := cfg.encrypt(n); err != nil {
+		// Never fall back to sending the message details in cleartext
+		log.Printf("Not notifying message %s of %s: cannot encrypt notification: %v", msg.ID, account, err)
+		return
+	}
+	cfg.dispatch(ctx, sinks, n)
+	if !cfg.KeepNotifications {
+		if err := history.Add(n, sinks); err != nil {
+			log.Printf("cannot save notification history: %v", err)
+		}
+	}
+}
+
+// isAutomation reports whether s is a webhook, MQTT or exec sink, which get
+// a document about the message rather than a notification
+func isAutomation(s *push.Sink) bool {
+	switch s.Notifier.(type) {
+	case *push.Webhook, *push.MQTT, *push.Exec:
+		return true
 	}
-	req.Header.Set("Title", "ProtonMail")
-	req.Header.Set("Click", "dismiss")
-	req.Header.Set("Tags", "envelope")
-	if _, err := http.DefaultClient.Do(req); err != nil {
-		log.Printf("failed to publish to push topic: %v", err)
+	return false
+}
+
+// dispatch sends n to sinks, giving each of them the message details it may
+// send. Webhook, MQTT and exec sinks get all of them, for automations. Chat
+// sinks get what the privacy level allows. When notifications are
+// encrypted, only webhook sinks get them.
+func (cfg *NtfyConfig) dispatch(ctx context.Context, sinks []*push.Sink, n *push.Notification) {
+	if n.Message == nil {
+		outbox.Dispatch(ctx, sinks, n)
 		return
 	}
-	log.Printf("Push event sent")
 
+	full := n.Message
+	shown := shownMessage(full, cfg.PrivacyLevel(n.Account))
+	encrypted := cfg.PGPPublicKey != ""
+	var messages []*push.Message
+	groups := make(map[*push.Message][]*push.Sink)
+	for _, s := range sinks {
+		var m *push.Message
+		switch s.Notifier.(type) {
+		case *push.Webhook:
+			m = full
+		case *push.Telegram, *push.Discord, *push.Slack:
+			if !encrypted {
+				m = shown
+			}
+		default:
+			if !encrypted {
+				m = full
+			}
+		}
+		if _, ok := groups[m]; !ok {
+			messages = append(messages, m)
+		}
+		groups[m] = append(groups[m], s)
+	}
+	for _, m := range messages {
+		sinkNotification := *n
+		sinkNotification.Message = m
+		outbox.Dispatch(ctx, groups[m], &sinkNotification)
+	}
 }
 
 // Read reads the configuration from file. Creates the file
@@ -108,70 +462,49 @@ func (cfg *NtfyConfig) Read() error {
 	}
 	return nil
 }
-
-func LoginBridge(cfg *NtfyConfig) error {
-	if cfg.BridgePw == "" {
-		cfg.BridgePw = os.Getenv("HYDROXIDE_BRIDGE_PASSWORD")
+func LoginBridge(cfg *NtfyConfig, username string) error {
+	acct := cfg.Account(username)
+	if acct.BridgePw == "" {
+		acct.BridgePw = os.Getenv("HYDROXIDE_BRIDGE_PASSWORD")
 	}
-	if cfg.BridgePw == "" {
+	if acct.BridgePw == "" {
 		scanner := bufio.NewScanner(os.Stdin)
-		fmt.Printf("Bridge password: ")
+		fmt.Printf("Bridge password for %s: ", username)
 		scanner.Scan()
-		cfg.BridgePw = scanner.Text()
+		acct.BridgePw = scanner.Text()
 
 	}
 	return nil
 }
-func Login(cfg *NtfyConfig, be backend.Backend) {
-	//time.Sleep(1 * time.Second)
-	c, _ := net.ResolveIPAddr("ip", "127.0.0.1")
-	conn := imap.ConnInfo{
-		RemoteAddr: c,
-		LocalAddr:  c,
-		TLS:        nil,
-	}
-	usernames, err := auth.ListUsernames()
-	if err != nil {
-		log.Fatal(err)
-	}
-	if len(usernames) > 1 {
-		log.Fatal("only one login supported for now")
-	}
-	err = cfg.Read()
-	if err != nil {
-		log.Println(err)
-	}
-	if len(usernames) == 0 || cfg.URL == "" || cfg.Topic == "" {
-		executable, _ := os.Executable()
-		log.Println("login first using " + executable + " auth <protonmail username>")
-		log.Fatalln("then setup ntfy using " + executable + "setup-ntfy")
-	}
-	if cfg.BridgePw == "" {
-		err = LoginBridge(cfg)
-		if err != nil {
-			log.Fatal(err)
+
+// Setup configures the push endpoint interactively or from the environment.
+// If username is set, the endpoint used only for that account is configured.
+func (cfg *NtfyConfig) Setup(username string) {
+	e := &cfg.Endpoint
+	if username != "" {
+		acct := cfg.Account(username)
+		if acct.Push == nil {
+			acct.Push = &Endpoint{URL: e.URL}
 		}
+		e = acct.Push
+		fmt.Printf("Configuring push endpoint for %s\n", username)
 	}
-	_, err = be.Login(&conn, usernames[0], cfg.BridgePw)
-	if err != nil {
-		log.Fatal(err)
-	}
-}
-
-func (cfg *NtfyConfig) Setup() {
 
 	// Configure using environment
 	if os.Getenv("PUSH_URL") != "" && os.Getenv("PUSH_TOPIC") != "" {
-		cfg.URL = os.Getenv("PUSH_URL")
-		cfg.Topic = os.Getenv("PUSH_TOPIC")
-		log.Printf("Current push endpoint: %s\n", cfg.URI())
+		e.URL = os.Getenv("PUSH_URL")
+		e.Topic = os.Getenv("PUSH_TOPIC")
+		log.Printf("Current push endpoint: %s\n", e.URI())
 		if os.Getenv("PUSH_USER") != "" && os.Getenv("PUSH_PASSWORD") != "" {
-			cfg.User = os.Getenv("PUSH_USER")
-			cfg.Password = base64.StdEncoding.EncodeToString([]byte(os.Getenv("PUSH_PASSWORD")))
+			e.User = os.Getenv("PUSH_USER")
+			e.Password = base64.StdEncoding.EncodeToString([]byte(os.Getenv("PUSH_PASSWORD")))
 			log.Println("Authentication for push endpoint configured using environment")
 		} else {
 			log.Println("Both PUSH_USER and PUSH_PASSWORD not set, assuming no authentication is necessary.")
 		}
+		if username == "" {
+			cfg.setupPrivacyFromEnv()
+		}
 		err := cfg.Save()
 		if err != nil {
 			log.Fatal(err)
@@ -180,11 +513,11 @@ func (cfg *NtfyConfig) Setup() {
 	}
 
 	var n string
-	if cfg.URL != "" && cfg.Topic != "" {
-		fmt.Printf("Current push endpoint: %s\n", cfg.URI())
+	if e.URL != "" && e.Topic != "" {
+		fmt.Printf("Current push endpoint: %s\n", e.URI())
 		n = "new "
 	}
-	if cfg.User != "" && cfg.Password != "" {
+	if e.User != "" && e.Password != "" {
 		fmt.Println("Push is currently configured for basic auth. You'll need to input credentials again")
 	}
 
@@ -192,8 +525,8 @@ func (cfg *NtfyConfig) Setup() {
 	notValid := true
 	scanner := bufio.NewScanner(os.Stdin)
 	for notValid {
-		tmpURL := cfg.URL
-		fmt.Printf("Input %spush server URL ('%s') : ", n, cfg.URL)
+		tmpURL := e.URL
+		fmt.Printf("Input %spush server URL ('%s') : ", n, e.URL)
 		scanner.Scan()
 		if len(scanner.Text()) > 0 {
 			tmpURL = scanner.Text()
@@ -202,22 +535,22 @@ func (cfg *NtfyConfig) Setup() {
 			fmt.Printf("Not a valid URL: %s\n", tmpURL)
 		} else {
 			notValid = false
-			cfg.URL = tmpURL
+			e.URL = tmpURL
 		}
 	}
 	scanner = bufio.NewScanner(os.Stdin)
 	// Read push topic
-	fmt.Printf("Input push topic ('%s'): ", cfg.Topic)
+	fmt.Printf("Input push topic ('%s'): ", e.Topic)
 	scanner.Scan()
 	if len(scanner.Text()) > 0 {
-		cfg.Topic = scanner.Text()
+		e.Topic = scanner.Text()
 	}
-	fmt.Printf("Using URL %s\n", cfg.URI())
+	fmt.Printf("Using URL %s\n", e.URI())
 	// Configure HTTP Basic Auth for push
 	// This needs to be input each time the auth flow is done,
 	// existing values are reset
-	cfg.User = ""
-	cfg.Password = ""
+	e.User = ""
+	e.Password = ""
 	fmt.Println("Configuring HTTP basic authentication for push endpoint.")
 	fmt.Println("Previously set username and password have been cleared.")
 	fmt.Println("Leave values blank to disable basic authentication.")
@@ -225,7 +558,7 @@ func (cfg *NtfyConfig) Setup() {
 	fmt.Printf("Username: ")
 	scanner.Scan()
 	if len(scanner.Text()) > 0 {
-		cfg.User = scanner.Text()
+		e.User = scanner.Text()
 	}
 	fmt.Printf("Password: ")
 	pwBytes, err := terminal.ReadPassword(0)
@@ -235,17 +568,64 @@ func (cfg *NtfyConfig) Setup() {
 	}
 	if len(pwBytes) > 0 {
 		// Store the password in base64 for a little obfuscation
-		cfg.Password = base64.StdEncoding.EncodeToString(pwBytes)
+		e.Password = base64.StdEncoding.EncodeToString(pwBytes)
 	}
-	// Save bridge password
-	if len(cfg.BridgePw) == 0 {
-		err := LoginBridge(cfg)
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+	fmt.Println()
+	if username == "" {
+		cfg.setupPrivacy(scanner)
+	}
+	cfg.saveSetup()
+}
+
+// setupPrivacy reads the notification privacy level
+func (cfg *NtfyConfig) setupPrivacy(scanner *bufio.Scanner) {
+	fmt.Println("Choose what notifications show about new messages:")
+	fmt.Printf("  %s: nothing, %s: the sender, %s: the sender and the subject\n", PrivacyGeneric, PrivacySender, PrivacySubject)
+	for {
+		fmt.Printf("Privacy level ('%s'): ", cfg.PrivacyLevel(""))
+		scanner.Scan()
+		privacy := scanner.Text()
+		if privacy == "" {
+			break
+		} else if validPrivacy(privacy) {
+			cfg.Privacy = privacy
+			break
+		}
+		fmt.Printf("Not a valid privacy level: %s\n", privacy)
+	}
+}
+
+// setupPrivacyFromEnv sets the notification privacy level from PUSH_PRIVACY
+func (cfg *NtfyConfig) setupPrivacyFromEnv() {
+	privacy := os.Getenv("PUSH_PRIVACY")
+	if privacy == "" {
+		return
+	}
+	if !validPrivacy(privacy) {
+		log.Fatalf("invalid PUSH_PRIVACY %q, use one of %s, %s or %s", privacy, PrivacyGeneric, PrivacySender, PrivacySubject)
+	}
+	cfg.Privacy = privacy
+	log.Printf("Notification privacy level set to %s\n", privacy)
+}
+
+// saveSetup asks for the missing bridge passwords and saves the
+// configuration at the end of an interactive setup
+func (cfg *NtfyConfig) saveSetup() {
+	usernames, err := auth.ListUsernames()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	for _, username := range usernames {
+		if cfg.BridgePassword(username) == "" {
+			err := LoginBridge(cfg, username)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		} else {
+			fmt.Printf("Bridge password for %s is set\n", username)
 		}
-	} else {
-		fmt.Println("Bridge password is set")
 	}
 	// Save configuration
 	err = cfg.Save()
@@ -0,0 +1,100 @@
+package ntfy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+
+	"github.com/0ranki/hydroxide-push/config"
+	"github.com/0ranki/hydroxide-push/push"
+)
+
+// encryptedTitle is the cleartext title of encrypted notifications
+const encryptedTitle = "ProtonMail"
+
+// readPGPKey reads the public key notifications are encrypted to. key is
+// either an armored key or the path of a file containing one, relative to
+// the configuration directory.
+func readPGPKey(key string) (openpgp.EntityList, error) {
+	var r io.Reader
+	if strings.HasPrefix(strings.TrimSpace(key), "-----BEGIN") {
+		r = strings.NewReader(key)
+	} else {
+		p := key
+		if !strings.HasPrefix(p, "/") {
+			var err error
+			if p, err = config.Path(key); err != nil {
+				return nil, err
+			}
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	keys, err := openpgp.ReadArmoredKeyRing(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OpenPGP public key: %v", err)
+	}
+	return keys, nil
+}
+
+// encryptedTags are the tags of encrypted notifications, replacing those
+// revealing the account or set by templates and rules
+var encryptedTags = []string{"envelope"}
+
+// encrypt replaces the title and body of n with a generic title and the
+// armored encryption of the title and body to the configured public key.
+// The tags are replaced and the click URL is reduced to its origin, which
+// may reveal the account or the message otherwise. Nothing is done if no
+// key is configured.
+func (cfg *NtfyConfig) encrypt(n *push.Notification) error {
+	if cfg.PGPPublicKey == "" {
+		return nil
+	}
+	keys, err := readPGPKey(cfg.PGPPublicKey)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	aw, err := armor.Encode(&buf, "PGP MESSAGE", nil)
+	if err != nil {
+		return err
+	}
+	w, err := openpgp.Encrypt(aw, keys, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, n.Title+"\n\n"+n.Body); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if err := aw.Close(); err != nil {
+		return err
+	}
+
+	n.Title = encryptedTitle
+	n.Body = buf.String()
+	n.Tags = encryptedTags
+	if n.Click != "" {
+		u, err := url.Parse(n.Click)
+		if err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+			n.Click = u.Scheme + "://" + u.Host + "/"
+		} else {
+			n.Click = ""
+		}
+	}
+	return nil
+}
@@ -0,0 +1,438 @@
+package ntfy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0ranki/hydroxide-push/config"
+	"github.com/0ranki/hydroxide-push/push"
+)
+
+// Quiet hours modes, choosing what happens to notifications during quiet
+// hours
+const (
+	// QuietDrop drops the notifications
+	QuietDrop = "drop"
+	// QuietMin sends the notifications with the minimum priority
+	QuietMin = "min"
+	// QuietHold holds the notifications and sends a summary when quiet
+	// hours end
+	QuietHold = "hold"
+)
+
+// QuietHours are the times new messages are not notified as usual
+type QuietHours struct {
+	// TimeZone is the IANA name of the time zone of the windows, local time
+	// by default
+	TimeZone string         `json:"timeZone,omitempty"`
+	Windows  []*QuietWindow `json:"windows"`
+	// Except lists dates, as YYYY-MM-DD, without quiet hours. A window
+	// spanning midnight is skipped if it starts on one of them.
+	Except []string `json:"except,omitempty"`
+	// Mode is one of QuietDrop, QuietMin (the default) or QuietHold
+	Mode string `json:"mode,omitempty"`
+	// Allow selects the messages notified as usual during quiet hours
+	Allow []*Match `json:"allow,omitempty"`
+}
+
+// QuietWindow is a daily time range, from Start to End as HH:MM. It spans
+// midnight if End is before Start.
+type QuietWindow struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+	// Days are the weekdays the window starts on, every day if empty
+	Days []string `json:"days,omitempty"`
+	// TimeZone overrides the time zone of the quiet hours
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+// parseClock parses HH:MM as minutes since midnight, allowing 24:00
+func parseClock(s string) (int, error) {
+	h, m, ok := strings.Cut(s, ":")
+	hours, err1 := strconv.Atoi(h)
+	minutes, err2 := strconv.Atoi(m)
+	if !ok || err1 != nil || err2 != nil || hours < 0 || minutes < 0 || minutes > 59 ||
+		hours*60+minutes > 24*60 {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	return hours*60 + minutes, nil
+}
+
+// startsOn reports whether w starts on the weekday of t
+func (w *QuietWindow) startsOn(t time.Time) (bool, error) {
+	if len(w.Days) == 0 {
+		return true, nil
+	}
+	for _, day := range w.Days {
+		if len(day) < 3 {
+			return false, fmt.Errorf("invalid weekday %q", day)
+		}
+		found := false
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			if strings.HasPrefix(strings.ToLower(d.String()), strings.ToLower(day)) {
+				found = true
+				if d == t.Weekday() {
+					return true, nil
+				}
+			}
+		}
+		if !found {
+			return false, fmt.Errorf("invalid weekday %q", day)
+		}
+	}
+	return false, nil
+}
+
+// activeAt reports whether t falls in the window in loc, except on the
+// dates of except
+func (w *QuietWindow) activeAt(t time.Time, loc *time.Location, except []string) (bool, error) {
+	start, err := parseClock(w.Start)
+	if err != nil {
+		return false, err
+	}
+	end, err := parseClock(w.End)
+	if err != nil {
+		return false, err
+	}
+	if w.TimeZone != "" {
+		if loc, err = time.LoadLocation(w.TimeZone); err != nil {
+			return false, fmt.Errorf("invalid time zone: %v", err)
+		}
+	}
+	t = t.In(loc)
+	now := t.Hour()*60 + t.Minute()
+
+	// The day the window containing t started on, if any
+	var day time.Time
+	switch {
+	case start < end && now >= start && now < end:
+		day = t
+	case start >= end && now >= start:
+		day = t
+	case start >= end && now < end:
+		day = t.AddDate(0, 0, -1)
+	default:
+		return false, nil
+	}
+	for _, date := range except {
+		if day.Format(time.DateOnly) == date {
+			return false, nil
+		}
+	}
+	return w.startsOn(day)
+}
+
+// active reports whether t falls in quiet hours
+func (q *QuietHours) active(t time.Time) (bool, error) {
+	if q == nil {
+		return false, nil
+	}
+	loc := time.Local
+	if q.TimeZone != "" {
+		var err error
+		if loc, err = time.LoadLocation(q.TimeZone); err != nil {
+			return false, fmt.Errorf("invalid time zone: %v", err)
+		}
+	}
+	for _, date := range q.Except {
+		if _, err := time.Parse(time.DateOnly, date); err != nil {
+			return false, fmt.Errorf("invalid exception date %q", date)
+		}
+	}
+	var errs []error
+	for i, w := range q.Windows {
+		ok, err := w.activeAt(t, loc, q.Except)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("window #%d: %v", i+1, err))
+		} else if ok {
+			return true, nil
+		}
+	}
+	return false, errors.Join(errs...)
+}
+
+// mode returns what happens to the notification of m at time t: an empty
+// string if it is sent as usual, otherwise the mode of the quiet hours
+func (q *QuietHours) mode(m *push.Message, t time.Time) (string, error) {
+	active, err := q.active(t)
+	if !active {
+		return "", err
+	}
+	for _, match := range q.Allow {
+		ok, err := match.Matches(m)
+		if err != nil {
+			return "", fmt.Errorf("invalid allow list: %v", err)
+		}
+		if ok {
+			return "", nil
+		}
+	}
+	switch q.Mode {
+	case QuietDrop, QuietHold:
+		return q.Mode, nil
+	case "", QuietMin:
+		return QuietMin, nil
+	}
+	return "", fmt.Errorf("invalid mode %q", q.Mode)
+}
+
+// maxSummaryLines is the number of held notifications listed in a summary
+const maxSummaryLines = 10
+
+// heldNotification is a notification held during quiet hours. Title and
+// Body are empty when notifications are encrypted, to keep the message
+// details off the disk.
+type heldNotification struct {
+	MessageID string    `json:"messageId"`
+	Title     string    `json:"title,omitempty"`
+	Body      string    `json:"body,omitempty"`
+	Priority  int       `json:"priority,omitempty"`
+	Time      time.Time `json:"time"`
+	// Targets and Topic are where the notification would have been sent,
+	// possibly chosen by a rule
+	Targets []string `json:"targets,omitempty"`
+	Topic   string   `json:"topic,omitempty"`
+}
+
+// destination returns a key identifying where h is sent
+func (h *heldNotification) destination() string {
+	return strings.Join(h.Targets, "\n") + "\n" + h.Topic
+}
+
+// held are the notifications held during quiet hours, by account. They are
+// saved in held.json in the configuration directory to survive restarts.
+var held struct {
+	sync.Mutex
+	m map[string][]*heldNotification
+}
+
+func heldFile() (string, error) {
+	return config.Path("held.json")
+}
+
+// loadHeld reads the held notifications if needed. held must be locked.
+func loadHeld() error {
+	if held.m != nil {
+		return nil
+	}
+	p, err := heldFile()
+	if err != nil {
+		return err
+	}
+	m := make(map[string][]*heldNotification)
+	b, err := os.ReadFile(p)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	} else if err == nil {
+		if err := json.Unmarshal(b, &m); err != nil {
+			return fmt.Errorf("invalid %s: %v", p, err)
+		}
+	}
+	held.m = m
+	return nil
+}
+
+// saveHeld writes the held notifications. held must be locked.
+func saveHeld() error {
+	p, err := heldFile()
+	if err != nil {
+		return err
+	}
+	if len(held.m) == 0 {
+		if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	b, err := json.Marshal(held.m)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(p, b, 0600)
+}
+
+// hold holds n, to be sent to sinks, until quiet hours end. Its title and
+// body are only saved if notifications are not encrypted.
+func hold(n *push.Notification, sinks []*push.Sink, encrypted bool) error {
+	h := &heldNotification{
+		MessageID: n.MessageID,
+		Priority:  n.Priority,
+		Time:      time.Now(),
+		Topic:     n.Topic,
+	}
+	if !encrypted {
+		h.Title = n.Title
+		h.Body = n.Body
+	}
+	for _, s := range sinks {
+		h.Targets = append(h.Targets, s.Target)
+	}
+
+	held.Lock()
+	defer held.Unlock()
+	if err := loadHeld(); err != nil {
+		return err
+	}
+	held.m[n.Account] = append(held.m[n.Account], h)
+	return saveHeld()
+}
+
+// unhold forgets the held notification of a message read or deleted before
+// quiet hours end
+func unhold(account, messageID string) {
+	held.Lock()
+	defer held.Unlock()
+	if err := loadHeld(); err != nil {
+		log.Printf("cannot read held notifications: %v", err)
+		return
+	}
+	list := held.m[account]
+	for i, h := range list {
+		if h.MessageID == messageID {
+			held.m[account] = append(list[:i:i], list[i+1:]...)
+			if len(held.m[account]) == 0 {
+				delete(held.m, account)
+			}
+			if err := saveHeld(); err != nil {
+				log.Printf("cannot save held notifications: %v", err)
+			}
+			return
+		}
+	}
+}
+
+// hasHeld reports whether notifications are held for account
+func hasHeld(account string) bool {
+	held.Lock()
+	defer held.Unlock()
+	if err := loadHeld(); err != nil {
+		log.Printf("cannot read held notifications: %v", err)
+		return false
+	}
+	return len(held.m[account]) > 0
+}
+
+// takeHeld removes and returns the notifications held for account
+func takeHeld(account string) ([]*heldNotification, error) {
+	held.Lock()
+	defer held.Unlock()
+	if err := loadHeld(); err != nil {
+		return nil, err
+	}
+	list, ok := held.m[account]
+	if !ok {
+		return nil, nil
+	}
+	delete(held.m, account)
+	return list, saveHeld()
+}
+
+// newSummary builds the notification summarizing the notifications held for
+// account and the same destination. It has their highest priority.
+func newSummary(account string, list []*heldNotification) *push.Notification {
+	title := fmt.Sprintf("%d new messages during quiet hours", len(list))
+	if len(list) == 1 {
+		title = "1 new message during quiet hours"
+	}
+	n := &push.Notification{
+		ID:      notificationID(account, "quiet-hours/"+list[0].Time.Format(time.RFC3339Nano)),
+		Account: account,
+		Title:   title,
+		Body:    "New messages received",
+		Tags:    []string{"envelope", account},
+		Topic:   list[0].Topic,
+	}
+	for _, h := range list {
+		if h.Priority > n.Priority {
+			n.Priority = h.Priority
+		}
+	}
+
+	// List the notifications, unless they all look the same as with the
+	// generic privacy level or their details were not saved
+	var lines []string
+	distinct := false
+	for i, h := range list {
+		if h.Title == "" {
+			distinct = false
+			break
+		}
+		line := h.Title
+		if h.Body != "" {
+			line += ": " + h.Body
+		}
+		if i > 0 && line != lines[0] {
+			distinct = true
+		}
+		lines = append(lines, line)
+	}
+	if distinct {
+		if len(lines) > maxSummaryLines {
+			lines = append(lines[:maxSummaryLines], fmt.Sprintf("and %d more", len(lines)-maxSummaryLines))
+		}
+		n.Body = strings.Join(lines, "\n")
+	}
+	return n
+}
+
+// releaseHeld sends the summaries of the notifications held for the account
+// once quiet hours have ended, one for each destination
+func (a *account) releaseHeld() {
+	if !hasHeld(a.username) {
+		return
+	}
+	cfg := NtfyConfig{}
+	if err := cfg.Read(); err != nil {
+		log.Printf("error reading configuration: %v\n", err)
+		return
+	}
+	active, err := cfg.QuietHours.active(time.Now())
+	if err != nil {
+		log.Printf("error evaluating quiet hours: %v\n", err)
+		return
+	}
+	if active {
+		return
+	}
+	list, err := takeHeld(a.username)
+	if err != nil {
+		log.Printf("cannot read held notifications: %v", err)
+	}
+
+	var destinations []string
+	groups := make(map[string][]*heldNotification)
+	for _, h := range list {
+		k := h.destination()
+		if _, ok := groups[k]; !ok {
+			destinations = append(destinations, k)
+		}
+		groups[k] = append(groups[k], h)
+	}
+	for _, k := range destinations {
+		group := groups[k]
+		var sinks []*push.Sink
+		if len(group[0].Targets) > 0 {
+			sinks, err = newSinks(group[0].Targets)
+		} else {
+			sinks, err = cfg.PushSinks(a.username)
+		}
+		if err != nil {
+			log.Printf("error configuring push sinks for %s: %v\n", a.username, err)
+			continue
+		}
+		n := newSummary(a.username, group)
+		if err := cfg.encrypt(n); err != nil {
+			log.Printf("Not sending the quiet hours summary of %s: cannot encrypt notification: %v", a.username, err)
+			continue
+		}
+		log.Printf("Quiet hours ended, sending the summary of %d held notification(s) of %s", len(group), a.username)
+		cfg.dispatch(a.d.pushCtx, sinks, n)
+	}
+}
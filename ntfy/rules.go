@@ -0,0 +1,131 @@
+package ntfy
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/0ranki/hydroxide-push/push"
+)
+
+// Match selects messages. All the conditions set must match, lists match if
+// any of their elements does. String comparisons are case-insensitive.
+type Match struct {
+	// Account is the account the message was received by
+	Account []string `json:"account,omitempty"`
+	// From is the sender address
+	From []string `json:"from,omitempty"`
+	// FromDomain is the domain of the sender address
+	FromDomain []string `json:"fromDomain,omitempty"`
+	// To is the Proton address or alias the message was received at,
+	// either as an email address or an address ID
+	To []string `json:"to,omitempty"`
+	// Subject is a regular expression matched against the subject
+	Subject string `json:"subject,omitempty"`
+	// Labels are label and folder names
+	Labels       []string `json:"labels,omitempty"`
+	MinSpamScore int      `json:"minSpamScore,omitempty"`
+	Attachments  *bool    `json:"attachments,omitempty"`
+	Starred      *bool    `json:"starred,omitempty"`
+}
+
+func matchAny(list []string, values ...string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if containsLabel(list, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches reports whether m matches all the conditions
+func (match *Match) Matches(m *push.Message) (bool, error) {
+	domain := m.Sender.Address
+	if i := strings.LastIndexByte(domain, '@'); i >= 0 {
+		domain = domain[i+1:]
+	}
+	labelMatch := len(match.Labels) == 0
+	for _, name := range m.Labels {
+		if containsLabel(match.Labels, name) {
+			labelMatch = true
+		}
+	}
+	if !matchAny(match.Account, m.Account) ||
+		!matchAny(match.From, m.Sender.Address) ||
+		!matchAny(match.FromDomain, domain) ||
+		!matchAny(match.To, m.Address, m.AddressID) ||
+		!labelMatch ||
+		m.SpamScore < match.MinSpamScore ||
+		(match.Attachments != nil && *match.Attachments != (m.Attachments > 0)) ||
+		(match.Starred != nil && *match.Starred != m.Starred) {
+		return false, nil
+	}
+
+	if match.Subject != "" {
+		re, err := regexp.Compile("(?i)" + match.Subject)
+		if err != nil {
+			return false, fmt.Errorf("invalid subject regexp: %v", err)
+		}
+		return re.MatchString(m.Subject), nil
+	}
+	return true, nil
+}
+
+// Rule changes how messages it matches are notified
+type Rule struct {
+	Name  string `json:"name,omitempty"`
+	Match Match  `json:"match"`
+
+	// Suppress disables notifications for matching messages
+	Suppress bool `json:"suppress,omitempty"`
+	// Priority is a number between 1 and 5, or its name
+	Priority string `json:"priority,omitempty"`
+	// Tags are added to the notification
+	Tags []string `json:"tags,omitempty"`
+	// Topic overrides the topic of ntfy sinks
+	Topic string `json:"topic,omitempty"`
+	// Sinks replace the sinks notifications are sent to
+	Sinks []string `json:"sinks,omitempty"`
+}
+
+func (r *Rule) String() string {
+	if r.Name != "" {
+		return fmt.Sprintf("%q", r.Name)
+	}
+	return "without name"
+}
+
+// apply changes n according to the rule
+func (r *Rule) apply(n *push.Notification) error {
+	if r.Priority != "" {
+		p, err := ParsePriority(r.Priority)
+		if err != nil {
+			return err
+		}
+		n.Priority = p
+	}
+	n.Tags = append(n.Tags, r.Tags...)
+	if r.Topic != "" {
+		n.Topic = r.Topic
+	}
+	return nil
+}
+
+// MatchRule returns the first rule matching m and its index, or nil if no
+// rule matches. Rules failing to evaluate are reported in errs and skipped.
+func (cfg *NtfyConfig) MatchRule(m *push.Message) (rule *Rule, index int, errs []error) {
+	for i, r := range cfg.Rules {
+		ok, err := r.Match.Matches(m)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rule #%d %v: %v", i+1, r, err))
+			continue
+		}
+		if ok {
+			return r, i, errs
+		}
+	}
+	return nil, -1, errs
+}
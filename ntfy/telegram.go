@@ -0,0 +1,41 @@
+package ntfy
+
+import (
+	"net/url"
+	"os"
+)
+
+// TelegramConfig is a Telegram chat notifications are sent to, in addition
+// to the push endpoint. TELEGRAM_BOT_TOKEN and TELEGRAM_CHAT_ID override
+// the values from the configuration file.
+type TelegramConfig struct {
+	BotToken string `json:"botToken"`
+	// ChatID is the numeric ID of the chat, or @channelname
+	ChatID string `json:"chatId"`
+}
+
+// telegramTarget returns the push target of the configured Telegram chat,
+// or an empty string if there is none
+func (cfg *NtfyConfig) telegramTarget() string {
+	var t TelegramConfig
+	if cfg.Telegram != nil {
+		t = *cfg.Telegram
+	}
+	if token := os.Getenv("TELEGRAM_BOT_TOKEN"); token != "" {
+		t.BotToken = token
+	}
+	if chatID := os.Getenv("TELEGRAM_CHAT_ID"); chatID != "" {
+		t.ChatID = chatID
+	}
+	if t.BotToken == "" || t.ChatID == "" {
+		return ""
+	}
+
+	u := url.URL{
+		Scheme:   "telegram",
+		Host:     "api.telegram.org",
+		Path:     "/" + t.ChatID,
+		RawQuery: url.Values{"token": {t.BotToken}}.Encode(),
+	}
+	return u.String()
+}
@@ -0,0 +1,154 @@
+package ntfy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/0ranki/hydroxide-push/push"
+)
+
+// Templates are text/template templates rendering notifications. They are
+// executed with the push.Message the notification is about. Empty templates
+// keep the value chosen by the privacy level.
+type Templates struct {
+	Title string `json:"title,omitempty"`
+	Body  string `json:"body,omitempty"`
+	// Tags renders to a comma-separated list of tags
+	Tags string `json:"tags,omitempty"`
+	// Priority renders to a number between 1 and 5, or one of min, low,
+	// default, high, max and urgent
+	Priority string `json:"priority,omitempty"`
+	Click    string `json:"click,omitempty"`
+}
+
+var templateFuncs = template.FuncMap{
+	"join":  strings.Join,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"trim":  strings.TrimSpace,
+}
+
+var priorities = map[string]int{
+	"min":     1,
+	"low":     2,
+	"default": 3,
+	"high":    4,
+	"max":     5,
+	"urgent":  5,
+}
+
+// ParsePriority parses a priority either as a number between 1 and 5 or as
+// its name
+func ParsePriority(s string) (int, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if p, ok := priorities[s]; ok {
+		return p, nil
+	}
+	p, err := strconv.Atoi(s)
+	if err != nil || p < 1 || p > 5 {
+		return 0, fmt.Errorf("invalid priority %q", s)
+	}
+	return p, nil
+}
+
+func render(name, text string, m *push.Message) (string, error) {
+	t, err := template.New(name).Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse %s template: %v", name, err)
+	}
+	var sb strings.Builder
+	if err := t.Execute(&sb, m); err != nil {
+		return "", fmt.Errorf("failed to render %s template: %v", name, err)
+	}
+	return sb.String(), nil
+}
+
+// Apply renders the templates for the message of n and overrides the
+// corresponding fields of n
+func (t *Templates) Apply(n *push.Notification) error {
+	if t == nil || n.Message == nil {
+		return nil
+	}
+
+	fields := []struct {
+		name string
+		text string
+		set  func(string) error
+	}{
+		{"title", t.Title, func(s string) error {
+			n.Title = s
+			return nil
+		}},
+		{"body", t.Body, func(s string) error {
+			n.Body = s
+			return nil
+		}},
+		{"tags", t.Tags, func(s string) error {
+			n.Tags = nil
+			for _, tag := range strings.Split(s, ",") {
+				if tag = strings.TrimSpace(tag); tag != "" {
+					n.Tags = append(n.Tags, tag)
+				}
+			}
+			return nil
+		}},
+		{"priority", t.Priority, func(s string) error {
+			p, err := ParsePriority(s)
+			n.Priority = p
+			return err
+		}},
+		{"click", t.Click, func(s string) error {
+			n.Click = strings.TrimSpace(s)
+			return nil
+		}},
+	}
+	for _, f := range fields {
+		if f.text == "" {
+			continue
+		}
+		s, err := render(f.name, f.text, n.Message)
+		if err != nil {
+			return err
+		}
+		if err := f.set(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SampleMessage returns a message used to preview notifications
+func SampleMessage(account string) *push.Message {
+	if account == "" {
+		account = "me@proton.me"
+	}
+	return &push.Message{
+		ID:      "sample-message-id",
+		Account: account,
+		Sender:  push.Address{Name: "Alice", Address: "alice@example.com"},
+		Recipients: []push.Address{
+			{Name: "Me", Address: account},
+		},
+		Subject:     "Lunch on Friday?",
+		Labels:      []string{"Inbox", "All Mail"},
+		Address:     account,
+		Time:        time.Now(),
+		Attachments: 1,
+	}
+}
+
+// RenderSample builds the notification sent for a sample message received
+// by account, using the current configuration
+func (cfg *NtfyConfig) RenderSample(account string) (*push.Notification, error) {
+	n := newNotification(SampleMessage(account), cfg.PrivacyLevel(account))
+	if err := cfg.Templates.Apply(n); err != nil {
+		return nil, err
+	}
+	if err := cfg.encrypt(n); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
@@ -12,9 +12,17 @@ const (
 	EventRefreshContacts
 )
 
+// API error codes returned by GetEvent when the event ID is invalid or no
+// longer known, in which case events cannot be replayed from it
+const (
+	ErrCodeInvalidID    = 2061
+	ErrCodeDoesNotExist = 2501
+)
+
 type Event struct {
 	ID       string `json:"EventID"`
 	Refresh  EventRefresh
+	More     int
 	Messages []*EventMessage
 	Contacts []*EventContact
 	//ContactEmails
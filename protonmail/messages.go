@@ -186,6 +186,13 @@ func (c *Client) ListMessages(filter *MessageFilter) (total int, messages []*Mes
 	if filter.Asc {
 		v.Set("Desc", "0")
 	}
+	if filter.Unread != nil {
+		if *filter.Unread {
+			v.Set("Unread", "1")
+		} else {
+			v.Set("Unread", "0")
+		}
+	}
 	if filter.Conversation != "" {
 		v.Set("Conversation", filter.Conversation)
 	}
@@ -0,0 +1,175 @@
+package push
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"log"
+	"net/url"
+	"sync"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	notificationsName      = "org.freedesktop.Notifications"
+	notificationsPath      = "/org/freedesktop/Notifications"
+	notificationsInterface = "org.freedesktop.Notifications"
+)
+
+// desktop is the session bus connection shared by the D-Bus sinks, along
+// with the IDs of the notifications shown
+var desktop struct {
+	sync.Mutex
+	conn   *dbus.Conn
+	markup bool
+	// ids maps Notification.ID to the ID of the notification shown, and
+	// shown maps it back
+	ids   map[string]uint32
+	shown map[uint32]string
+}
+
+// DBus shows desktop notifications through the org.freedesktop.Notifications
+// service on the session bus
+type DBus struct{}
+
+func newDBus(u *url.URL) (*DBus, error) {
+	if u.Host != "" || (u.Path != "" && u.Path != "/") {
+		return nil, fmt.Errorf("dbus target must be %s://", u.Scheme)
+	}
+	return &DBus{}, nil
+}
+
+// connect connects to the session bus if needed. desktop must be locked.
+func (d *DBus) connect() (dbus.BusObject, error) {
+	if desktop.conn != nil && desktop.conn.Connected() {
+		return desktop.conn.Object(notificationsName, notificationsPath), nil
+	}
+
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to the session bus: %v", err)
+	}
+	obj := conn.Object(notificationsName, notificationsPath)
+	var caps []string
+	if err := obj.Call(notificationsInterface+".GetCapabilities", 0).Store(&caps); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("no notification service: %v", err)
+	}
+
+	desktop.conn = conn
+	desktop.markup = false
+	for _, c := range caps {
+		if c == "body-markup" {
+			desktop.markup = true
+		}
+	}
+	desktop.ids = make(map[string]uint32)
+	desktop.shown = make(map[uint32]string)
+
+	// Forget the notifications closed by the user
+	err = conn.AddMatchSignal(
+		dbus.WithMatchInterface(notificationsInterface),
+		dbus.WithMatchMember("NotificationClosed"),
+	)
+	if err != nil {
+		log.Printf("cannot watch closed desktop notifications: %v", err)
+	}
+	signals := make(chan *dbus.Signal, 16)
+	conn.Signal(signals)
+	go func() {
+		for s := range signals {
+			if s.Name != notificationsInterface+".NotificationClosed" || len(s.Body) == 0 {
+				continue
+			}
+			if id, ok := s.Body[0].(uint32); ok {
+				desktop.Lock()
+				if notifID, ok := desktop.shown[id]; ok {
+					delete(desktop.ids, notifID)
+					delete(desktop.shown, id)
+				}
+				desktop.Unlock()
+			}
+		}
+	}()
+	return obj, nil
+}
+
+// closeDBus closes the session bus connection
+func closeDBus() {
+	desktop.Lock()
+	defer desktop.Unlock()
+	if desktop.conn != nil {
+		desktop.conn.Close()
+		desktop.conn = nil
+	}
+}
+
+// dbusUrgency maps priorities from 1 to 5 to the urgency hint: low, normal
+// or critical
+var dbusUrgency = [...]byte{1: 0, 2: 0, 3: 1, 4: 1, 5: 2}
+
+func (d *DBus) Notify(ctx context.Context, n *Notification) error {
+	desktop.Lock()
+	defer desktop.Unlock()
+
+	obj, err := d.connect()
+	if err != nil {
+		return err
+	}
+
+	body := n.Body
+	if desktop.markup {
+		body = html.EscapeString(body)
+	}
+	urgency := byte(1)
+	if n.Priority > 0 && n.Priority < len(dbusUrgency) {
+		urgency = dbusUrgency[n.Priority]
+	}
+	hints := map[string]dbus.Variant{
+		"urgency":  dbus.MakeVariant(urgency),
+		"category": dbus.MakeVariant("email.arrived"),
+	}
+	// Replace the notification if it is still shown, e.g. when retried
+	replaces := desktop.ids[n.ID]
+
+	var id uint32
+	call := obj.CallWithContext(ctx, notificationsInterface+".Notify", 0,
+		"Proton Mail", replaces, "mail-unread", n.Title, body, []string{}, hints, int32(-1))
+	if err := call.Store(&id); err != nil {
+		return err
+	}
+	if n.ID != "" {
+		desktop.ids[n.ID] = id
+		desktop.shown[id] = n.ID
+	}
+	return nil
+}
+
+// close closes the notification shown for n, if any
+func (d *DBus) close(ctx context.Context, n *Notification) error {
+	desktop.Lock()
+	defer desktop.Unlock()
+
+	id, ok := desktop.ids[n.ID]
+	if !ok || desktop.conn == nil {
+		return nil
+	}
+	obj, err := d.connect()
+	if err != nil {
+		return err
+	}
+	delete(desktop.ids, n.ID)
+	delete(desktop.shown, id)
+	return obj.CallWithContext(ctx, notificationsInterface+".CloseNotification", 0, id).Err
+}
+
+// Clear closes the notification, desktop notifications cannot be marked as
+// read
+func (d *DBus) Clear(ctx context.Context, n *Notification) error {
+	return d.close(ctx, n)
+}
+
+func (d *DBus) Delete(ctx context.Context, n *Notification) error {
+	return d.close(ctx, n)
+}
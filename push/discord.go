@@ -0,0 +1,100 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// embedColor is the color of Discord embeds, Proton purple
+const embedColor = 0x6d4aff
+
+// Discord posts notifications as embeds to a Discord webhook
+type Discord struct {
+	URL string
+}
+
+func newDiscord(u *url.URL) (*Discord, error) {
+	target := *u
+	target.Scheme = strings.TrimPrefix(u.Scheme, "discord+")
+	return &Discord{URL: target.String()}, nil
+}
+
+type discordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline,omitempty"`
+}
+
+type discordEmbed struct {
+	Title       string          `json:"title,omitempty"`
+	Description string          `json:"description,omitempty"`
+	URL         string          `json:"url,omitempty"`
+	Color       int             `json:"color"`
+	Fields      []*discordField `json:"fields,omitempty"`
+	Timestamp   string          `json:"timestamp,omitempty"`
+}
+
+type discordMessage struct {
+	Username string          `json:"username"`
+	Embeds   []*discordEmbed `json:"embeds"`
+}
+
+// truncate shortens s to at most n runes
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
+
+func newDiscordMessage(n *Notification) *discordMessage {
+	embed := &discordEmbed{
+		Title:       truncate(n.Title, 256),
+		Description: truncate(n.Body, 4096),
+		Color:       embedColor,
+	}
+	if strings.HasPrefix(n.Click, "http://") || strings.HasPrefix(n.Click, "https://") {
+		embed.URL = n.Click
+	}
+	if m := n.Message; m != nil {
+		fields := []struct{ name, value string }{
+			{"From", m.Sender.String()},
+			{"Subject", m.Subject},
+			{"Labels", strings.Join(m.Labels, ", ")},
+		}
+		for _, f := range fields {
+			if f.value != "" {
+				embed.Fields = append(embed.Fields, &discordField{
+					Name:   f.name,
+					Value:  truncate(f.value, 1024),
+					Inline: f.name != "Subject",
+				})
+			}
+		}
+		if !m.Time.IsZero() {
+			embed.Timestamp = m.Time.UTC().Format(time.RFC3339)
+		}
+	}
+	return &discordMessage{Username: "Proton Mail", Embeds: []*discordEmbed{embed}}
+}
+
+func (d *Discord) Notify(ctx context.Context, n *Notification) error {
+	b, err := json.Marshal(newDiscordMessage(n))
+	if err != nil {
+		return err
+	}
+	return doRateLimited(ctx, d.URL, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
+}
@@ -0,0 +1,172 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultExecTimeout     = 30 * time.Second
+	defaultExecConcurrency = 4
+)
+
+// execSlots limits the number of concurrent runs of each command, indexed
+// by target
+var execSlots = struct {
+	sync.Mutex
+	m map[string]chan struct{}
+}{m: make(map[string]chan struct{})}
+
+// Exec runs a command for each notification. The message is passed in HP_*
+// environment variables and as the JSON document posted by Webhook on
+// stdin.
+type Exec struct {
+	Path    string
+	Args    []string
+	Timeout time.Duration
+	// slots holds a value for each running command
+	slots chan struct{}
+}
+
+func newExec(u *url.URL) (*Exec, error) {
+	if u.Path == "" {
+		return nil, fmt.Errorf("exec target must be of the form %s:///path/to/command", u.Scheme)
+	}
+	q := u.Query()
+	e := &Exec{
+		Path:    u.Path,
+		Args:    q["arg"],
+		Timeout: defaultExecTimeout,
+	}
+	if q.Has("timeout") {
+		var err error
+		if e.Timeout, err = time.ParseDuration(q.Get("timeout")); err != nil {
+			return nil, fmt.Errorf("invalid exec timeout: %v", err)
+		}
+	}
+	concurrency := defaultExecConcurrency
+	if q.Has("concurrency") {
+		var err error
+		if concurrency, err = strconv.Atoi(q.Get("concurrency")); err != nil || concurrency < 1 {
+			return nil, fmt.Errorf("invalid exec concurrency %q", q.Get("concurrency"))
+		}
+	}
+
+	target := u.String()
+	execSlots.Lock()
+	defer execSlots.Unlock()
+	slots, ok := execSlots.m[target]
+	if !ok {
+		slots = make(chan struct{}, concurrency)
+		execSlots.m[target] = slots
+	}
+	e.slots = slots
+	return e, nil
+}
+
+// execEnv returns the HP_* environment variables describing n
+func execEnv(n *Notification) []string {
+	vars := map[string]string{
+		"HP_ACCOUNT":    n.Account,
+		"HP_MESSAGE_ID": n.MessageID,
+		"HP_TITLE":      n.Title,
+		"HP_BODY":       n.Body,
+		"HP_TAGS":       strings.Join(n.Tags, ","),
+		"HP_PRIORITY":   strconv.Itoa(n.Priority),
+		"HP_CLICK":      n.Click,
+	}
+	if m := n.Message; m != nil {
+		var recipients []string
+		for _, addr := range m.Recipients {
+			recipients = append(recipients, addr.String())
+		}
+		vars["HP_EVENT_ID"] = m.EventID
+		vars["HP_SENDER"] = m.Sender.String()
+		vars["HP_SENDER_NAME"] = m.Sender.Name
+		vars["HP_SENDER_ADDRESS"] = m.Sender.Address
+		vars["HP_RECIPIENTS"] = strings.Join(recipients, ", ")
+		vars["HP_ADDRESS"] = m.Address
+		vars["HP_SUBJECT"] = m.Subject
+		vars["HP_LABELS"] = strings.Join(m.Labels, ",")
+		vars["HP_TIME"] = m.Time.Format(time.RFC3339)
+		vars["HP_ATTACHMENTS"] = strconv.Itoa(m.Attachments)
+		vars["HP_STARRED"] = strconv.FormatBool(m.Starred)
+	}
+
+	env := os.Environ()
+	for k, v := range vars {
+		env = append(env, k+"="+v)
+	}
+	return env
+}
+
+// logWriter logs the lines written to it
+type logWriter struct {
+	name string
+	buf  []byte
+}
+
+func (w *logWriter) Write(b []byte) (int, error) {
+	w.buf = append(w.buf, b...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		log.Printf("%s: %s", w.name, w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+	return len(b), nil
+}
+
+// flush logs the last line if it is not terminated
+func (w *logWriter) flush() {
+	if len(w.buf) > 0 {
+		log.Printf("%s: %s", w.name, w.buf)
+		w.buf = nil
+	}
+}
+
+func (e *Exec) Notify(ctx context.Context, n *Notification) error {
+	stdin, err := json.Marshal(newWebhookEvent(n))
+	if err != nil {
+		return err
+	}
+
+	select {
+	case e.slots <- struct{}{}:
+		defer func() { <-e.slots }()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.Timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, e.Path, e.Args...)
+	cmd.Env = execEnv(n)
+	cmd.Stdin = bytes.NewReader(stdin)
+	// Don't wait for the output of processes started by the command
+	cmd.WaitDelay = time.Second
+
+	stdout := &logWriter{name: e.Path}
+	stderr := &logWriter{name: e.Path}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err = cmd.Run()
+	stdout.flush()
+	stderr.flush()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("command timed out after %v", e.Timeout)
+	}
+	return err
+}
@@ -0,0 +1,75 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Gotify publishes notifications to a Gotify server as an application
+type Gotify struct {
+	// URL is the base URL of the Gotify server
+	URL string
+	// Token is the application token
+	Token string
+}
+
+func newGotify(u *url.URL) (*Gotify, error) {
+	token := u.Query().Get("token")
+	if u.Host == "" || token == "" {
+		return nil, fmt.Errorf("gotify target must be of the form %s://host/?token=apptoken", u.Scheme)
+	}
+
+	scheme := "http"
+	if u.Scheme == "gotifys" {
+		scheme = "https"
+	}
+	return &Gotify{
+		URL:   scheme + "://" + u.Host + strings.TrimSuffix(u.Path, "/"),
+		Token: token,
+	}, nil
+}
+
+// gotifyPriority maps ntfy priorities from 1 to 5 to Gotify priorities
+// from 0 to 10. Gotify Android shows a popup from priority 8.
+var gotifyPriority = [...]int{1: 1, 2: 3, 3: 5, 4: 8, 5: 10}
+
+type gotifyMessage struct {
+	Title    string                 `json:"title,omitempty"`
+	Message  string                 `json:"message"`
+	Priority *int                   `json:"priority,omitempty"`
+	Extras   map[string]interface{} `json:"extras,omitempty"`
+}
+
+func (g *Gotify) Notify(ctx context.Context, notif *Notification) error {
+	msg := gotifyMessage{
+		Title:   notif.Title,
+		Message: notif.Body,
+	}
+	if notif.Priority > 0 && notif.Priority < len(gotifyPriority) {
+		msg.Priority = &gotifyPriority[notif.Priority]
+	}
+	if notif.Click != "" {
+		msg.Extras = map[string]interface{}{
+			"client::notification": map[string]interface{}{
+				"click": map[string]string{"url": notif.Click},
+			},
+		}
+	}
+	b, err := json.Marshal(&msg)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.URL+"/message", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gotify-Key", g.Token)
+	return do(req)
+}
@@ -0,0 +1,256 @@
+package push
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+var (
+	historyBucket  = []byte("history")
+	receiptsBucket = []byte("receipts")
+)
+
+// receipts is the open history, where sinks remember the receipts needed to
+// withdraw their notifications
+var receipts *History
+
+// receipt identifies a notification in a push service, indexed by
+// Notification.ID
+type receipt struct {
+	Receipt string
+	Time    time.Time
+}
+
+// sent is a notification published for a message to sinks able to
+// withdraw it
+type sent struct {
+	Notification *Notification
+	Targets      []string
+	Time         time.Time
+	Cleared      bool
+}
+
+// History remembers the notifications sent for each message, so they can be
+// cleared when the message is read or deleted elsewhere
+type History struct {
+	db     *bolt.DB
+	maxAge time.Duration
+}
+
+// OpenHistory opens the history stored at path. Notifications older than
+// maxAge are forgotten.
+func OpenHistory(path string, maxAge time.Duration) (*History, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{historyBucket, receiptsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	h := &History{db: db, maxAge: maxAge}
+	receipts = h
+	return h, nil
+}
+
+func (h *History) Close() error {
+	if receipts == h {
+		receipts = nil
+	}
+	return h.db.Close()
+}
+
+// putReceipt remembers the receipt of notification id. Nothing is done if
+// no history is open.
+func (h *History) putReceipt(id, r string) error {
+	if h == nil {
+		return nil
+	}
+	v, err := json.Marshal(&receipt{Receipt: r, Time: time.Now()})
+	if err != nil {
+		return err
+	}
+	return h.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(receiptsBucket)
+		var expired [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			var r receipt
+			if err := json.Unmarshal(v, &r); err != nil || time.Since(r.Time) > h.maxAge {
+				expired = append(expired, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return b.Put([]byte(id), v)
+	})
+}
+
+// takeReceipt removes and returns the receipt of notification id, or an
+// empty string if there is none
+func (h *History) takeReceipt(id string) (string, error) {
+	if h == nil {
+		return "", nil
+	}
+	var r receipt
+	err := h.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(receiptsBucket)
+		v := b.Get([]byte(id))
+		if v == nil {
+			return nil
+		}
+		if err := json.Unmarshal(v, &r); err != nil {
+			return err
+		}
+		return b.Delete([]byte(id))
+	})
+	return r.Receipt, err
+}
+
+func historyKey(account, messageID string) []byte {
+	return []byte(account + "/" + messageID)
+}
+
+// Add remembers n was sent to sinks. Only sinks implementing Clearer are
+// recorded.
+func (h *History) Add(n *Notification, sinks []*Sink) error {
+	if h == nil || n.ID == "" {
+		return nil
+	}
+	entry := &sent{
+		// Only keep what is needed to identify the notification
+		Notification: &Notification{
+			ID:        n.ID,
+			Account:   n.Account,
+			MessageID: n.MessageID,
+			Topic:     n.Topic,
+		},
+		Time: time.Now(),
+	}
+	for _, s := range sinks {
+		if _, ok := s.Notifier.(Clearer); ok {
+			entry.Targets = append(entry.Targets, s.Target)
+		}
+	}
+	if len(entry.Targets) == 0 {
+		return nil
+	}
+
+	v, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return h.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(historyBucket)
+		if err := h.prune(b); err != nil {
+			return err
+		}
+		return b.Put(historyKey(n.Account, n.MessageID), v)
+	})
+}
+
+// prune forgets the notifications older than the maximum age
+func (h *History) prune(b *bolt.Bucket) error {
+	var expired [][]byte
+	err := b.ForEach(func(k, v []byte) error {
+		var entry sent
+		if err := json.Unmarshal(v, &entry); err != nil || time.Since(entry.Time) > h.maxAge {
+			expired = append(expired, k)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range expired {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Withdraw clears the notification sent for a message, or deletes it if del
+// is set. Nothing is done if no notification was sent for the message.
+func (h *History) Withdraw(ctx context.Context, account, messageID string, del bool) {
+	if h == nil {
+		return
+	}
+
+	k := historyKey(account, messageID)
+	var entry *sent
+	err := h.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(historyBucket).Get(k)
+		if v == nil {
+			return nil
+		}
+		entry = new(sent)
+		return json.Unmarshal(v, entry)
+	})
+	if err != nil {
+		log.Printf("cannot read notification history: %v", err)
+		return
+	} else if entry == nil || (entry.Cleared && !del) {
+		return
+	}
+
+	action := "cleared"
+	if del {
+		action = "deleted"
+	}
+	for _, target := range entry.Targets {
+		s, err := New(target)
+		if err != nil {
+			log.Printf("cannot withdraw notification: %v", err)
+			continue
+		}
+		c, ok := s.Notifier.(Clearer)
+		if !ok {
+			continue
+		}
+		if del {
+			err = c.Delete(ctx, entry.Notification)
+		} else {
+			err = c.Clear(ctx, entry.Notification)
+		}
+		if err != nil {
+			log.Printf("failed to withdraw notification from %s for %s: %v", s.Name, account, err)
+		} else {
+			log.Printf("Notification for message %s %s from %s", messageID, action, s.Name)
+		}
+	}
+
+	err = h.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(historyBucket)
+		if del {
+			return b.Delete(k)
+		}
+		entry.Cleared = true
+		v, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		return b.Put(k, v)
+	})
+	if err != nil {
+		log.Printf("cannot update notification history: %v", err)
+	}
+}
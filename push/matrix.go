@@ -0,0 +1,108 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Matrix sends notifications as m.notice events to a Matrix room
+type Matrix struct {
+	// URL is the base URL of the homeserver
+	URL    string
+	RoomID string
+	// Token is the access token of the sending user
+	Token string
+}
+
+func newMatrix(u *url.URL) (*Matrix, error) {
+	// The room ID is the last path element, anything before it is part of
+	// the homeserver URL
+	p := strings.Trim(u.Path, "/")
+	token := u.Query().Get("token")
+	if u.Host == "" || p == "" || token == "" {
+		return nil, fmt.Errorf("matrix target must be of the form %s://host/!room:server?token=accesstoken", u.Scheme)
+	}
+
+	scheme := "http"
+	if u.Scheme == "matrixs" {
+		scheme = "https"
+	}
+	base := scheme + "://" + u.Host
+	if dir := path.Dir(p); dir != "." {
+		base += "/" + dir
+	}
+	return &Matrix{
+		URL:    base,
+		RoomID: path.Base(p),
+		Token:  token,
+	}, nil
+}
+
+type matrixNotice struct {
+	MsgType       string `json:"msgtype"`
+	Body          string `json:"body"`
+	Format        string `json:"format"`
+	FormattedBody string `json:"formatted_body"`
+}
+
+func newMatrixNotice(n *Notification) *matrixNotice {
+	plain := n.Title + "\n" + n.Body
+	formatted := "<b>" + html.EscapeString(n.Title) + "</b><br>" +
+		strings.ReplaceAll(html.EscapeString(n.Body), "\n", "<br>")
+	if n.Click != "" {
+		plain += "\n" + n.Click
+		formatted += `<br><a href="` + html.EscapeString(n.Click) + `">Open</a>`
+	}
+	return &matrixNotice{
+		MsgType:       "m.notice",
+		Body:          plain,
+		Format:        "org.matrix.custom.html",
+		FormattedBody: formatted,
+	}
+}
+
+// txnID returns the transaction ID of the event sent for n. It is derived
+// from the notification ID, so that the homeserver ignores retries of an
+// event it already received.
+func (m *Matrix) txnID(n *Notification) (string, error) {
+	if n.ID == "" {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(b), nil
+	}
+	sum := sha256.Sum256([]byte(m.RoomID + "/" + n.ID))
+	return hex.EncodeToString(sum[:16]), nil
+}
+
+func (m *Matrix) Notify(ctx context.Context, n *Notification) error {
+	b, err := json.Marshal(newMatrixNotice(n))
+	if err != nil {
+		return err
+	}
+	txnID, err := m.txnID(n)
+	if err != nil {
+		return err
+	}
+
+	uri := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s",
+		m.URL, url.PathEscape(m.RoomID), txnID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, uri, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+m.Token)
+	return do(req)
+}
@@ -0,0 +1,262 @@
+package push
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	defaultMQTTPrefix     = "hydroxide"
+	defaultDiscoveryTopic = "homeassistant"
+	mqttConnectTimeout    = 30 * time.Second
+)
+
+// MQTT publishes new message events and message counts to an MQTT broker.
+// Connections are kept open and shared by the sinks created for the same
+// target.
+type MQTT struct {
+	client mqtt.Client
+	// Prefix is prepended to the published topics
+	Prefix string
+	// Discovery is the Home Assistant discovery prefix, empty if discovery
+	// is disabled
+	Discovery string
+
+	connLock sync.Mutex
+
+	sync.Mutex // protects discovered
+	// discovered holds the discovery topics already published
+	discovered map[string]bool
+}
+
+var mqttClients = struct {
+	sync.Mutex
+	m map[string]*MQTT // indexed by target
+}{m: make(map[string]*MQTT)}
+
+func newMQTT(u *url.URL) (*MQTT, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("mqtt target must be of the form %s://host[:port]/prefix", u.Scheme)
+	}
+
+	target := u.String()
+	mqttClients.Lock()
+	defer mqttClients.Unlock()
+	if m, ok := mqttClients.m[target]; ok {
+		return m, nil
+	}
+
+	m := &MQTT{
+		Prefix:     strings.Trim(u.Path, "/"),
+		Discovery:  defaultDiscoveryTopic,
+		discovered: make(map[string]bool),
+	}
+	if m.Prefix == "" {
+		m.Prefix = defaultMQTTPrefix
+	}
+	if q := u.Query(); q.Has("discovery") {
+		m.Discovery = q.Get("discovery")
+		if m.Discovery == "off" {
+			m.Discovery = ""
+		}
+	}
+
+	broker := "tcp://" + u.Host
+	if u.Port() == "" {
+		broker += ":1883"
+	}
+	if u.Scheme == "mqtts" {
+		broker = "ssl://" + u.Host
+		if u.Port() == "" {
+			broker += ":8883"
+		}
+	}
+	clientID := make([]byte, 8)
+	if _, err := rand.Read(clientID); err != nil {
+		return nil, err
+	}
+	opts := mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID("hydroxide-push-"+hex.EncodeToString(clientID)).
+		SetAutoReconnect(true).
+		SetConnectTimeout(mqttConnectTimeout).
+		SetWill(m.statusTopic(), "offline", 1, true).
+		SetOnConnectHandler(func(c mqtt.Client) {
+			c.Publish(m.statusTopic(), 1, true, "online")
+		})
+	if u.User != nil {
+		opts.SetUsername(u.User.Username())
+		if pw, ok := u.User.Password(); ok {
+			opts.SetPassword(pw)
+		}
+	}
+	m.client = mqtt.NewClient(opts)
+	mqttClients.m[target] = m
+	return m, nil
+}
+
+// closeMQTT disconnects from all the brokers
+func closeMQTT() {
+	mqttClients.Lock()
+	defer mqttClients.Unlock()
+
+	for target, m := range mqttClients.m {
+		if m.client.IsConnectionOpen() {
+			t := m.client.Publish(m.statusTopic(), 1, true, "offline")
+			t.WaitTimeout(time.Second)
+			m.client.Disconnect(250)
+		}
+		delete(mqttClients.m, target)
+	}
+}
+
+func wait(ctx context.Context, t mqtt.Token) error {
+	select {
+	case <-t.Done():
+		return t.Error()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (m *MQTT) connect(ctx context.Context) error {
+	m.connLock.Lock()
+	defer m.connLock.Unlock()
+
+	// The client reconnects by itself once connected
+	if m.client.IsConnected() {
+		return nil
+	}
+	return wait(ctx, m.client.Connect())
+}
+
+func (m *MQTT) publish(ctx context.Context, topic string, retained bool, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := m.connect(ctx); err != nil {
+		return err
+	}
+	return wait(ctx, m.client.Publish(topic, 1, retained, b))
+}
+
+var topicUnsafe = regexp.MustCompile(`[/+#\x00]`)
+
+// topicLevel returns s usable as a single topic level
+func topicLevel(s string) string {
+	return topicUnsafe.ReplaceAllString(s, "_")
+}
+
+var objectIDUnsafe = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+// objectID returns s usable as a Home Assistant object ID
+func objectID(s string) string {
+	return objectIDUnsafe.ReplaceAllString(strings.ToLower(s), "_")
+}
+
+func (m *MQTT) statusTopic() string {
+	return m.Prefix + "/status"
+}
+
+func (m *MQTT) countsTopic(account, label string) string {
+	return m.Prefix + "/" + topicLevel(account) + "/" + topicLevel(label)
+}
+
+// Notify publishes the new message event documented for webhooks to
+// prefix/account/message
+func (m *MQTT) Notify(ctx context.Context, n *Notification) error {
+	return m.publish(ctx, m.Prefix+"/"+topicLevel(n.Account)+"/message", false, newWebhookEvent(n))
+}
+
+// PublishCounts publishes retained message counts to prefix/account/label
+func (m *MQTT) PublishCounts(ctx context.Context, account string, counts []LabelCount) error {
+	for _, count := range counts {
+		if err := m.discover(ctx, account, count.Label); err != nil {
+			return err
+		}
+		if err := m.publish(ctx, m.countsTopic(account, count.Label), true, count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type haDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+}
+
+type haSensor struct {
+	Name              string    `json:"name"`
+	UniqueID          string    `json:"unique_id"`
+	StateTopic        string    `json:"state_topic"`
+	ValueTemplate     string    `json:"value_template"`
+	Unit              string    `json:"unit_of_measurement"`
+	StateClass        string    `json:"state_class"`
+	Icon              string    `json:"icon"`
+	AvailabilityTopic string    `json:"availability_topic"`
+	Device            *haDevice `json:"device"`
+}
+
+// discover publishes the Home Assistant discovery configs of the unread and
+// total count sensors of a label, once
+func (m *MQTT) discover(ctx context.Context, account, label string) error {
+	if m.Discovery == "" {
+		return nil
+	}
+
+	device := &haDevice{
+		Identifiers:  []string{"hydroxide_push_" + objectID(account)},
+		Name:         "Proton Mail " + account,
+		Manufacturer: "hydroxide-push",
+	}
+	for _, field := range []string{"unread", "total"} {
+		id := objectID("hydroxide_" + account + "_" + label + "_" + field)
+		topic := m.Discovery + "/sensor/" + id + "/config"
+
+		m.Lock()
+		done := m.discovered[topic]
+		m.Unlock()
+		if done {
+			continue
+		}
+
+		icon := "mdi:email-multiple"
+		if field == "unread" {
+			icon = "mdi:email-alert"
+		}
+		err := m.publish(ctx, topic, true, &haSensor{
+			Name:              label + " " + field,
+			UniqueID:          id,
+			StateTopic:        m.countsTopic(account, label),
+			ValueTemplate:     "{{ value_json." + field + " }}",
+			Unit:              "messages",
+			StateClass:        "measurement",
+			Icon:              icon,
+			AvailabilityTopic: m.statusTopic(),
+			Device:            device,
+		})
+		if err != nil {
+			return err
+		}
+		log.Printf("Published Home Assistant discovery config %s", topic)
+
+		m.Lock()
+		m.discovered[topic] = true
+		m.Unlock()
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package push
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Ntfy publishes notifications to an ntfy topic
+type Ntfy struct {
+	// URL is the base URL of the ntfy server
+	URL      string
+	Topic    string
+	User     string
+	Password string
+}
+
+func newNtfy(u *url.URL) (*Ntfy, error) {
+	// The topic is the last path element, anything before it is part of
+	// the server URL
+	p := strings.Trim(u.Path, "/")
+	topic := path.Base(p)
+	if u.Host == "" || p == "" {
+		return nil, fmt.Errorf("ntfy target must be of the form %s://host/topic", u.Scheme)
+	}
+
+	scheme := "http"
+	if u.Scheme == "ntfys" {
+		scheme = "https"
+	}
+	base := scheme + "://" + u.Host
+	if dir := path.Dir(p); dir != "." {
+		base += "/" + dir
+	}
+	n := &Ntfy{
+		URL:   base,
+		Topic: topic,
+	}
+	if u.User != nil {
+		n.User = u.User.Username()
+		n.Password, _ = u.User.Password()
+	}
+	return n, nil
+}
+
+func (n *Ntfy) URI() string {
+	return fmt.Sprintf("%s/%s", n.URL, n.Topic)
+}
+
+// topicURI returns the URI of the topic notif is published to
+func (n *Ntfy) topicURI(notif *Notification) string {
+	if notif.Topic != "" {
+		return fmt.Sprintf("%s/%s", n.URL, notif.Topic)
+	}
+	return n.URI()
+}
+
+func (n *Ntfy) newRequest(ctx context.Context, method, uri string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, uri, body)
+	if err != nil {
+		return nil, err
+	}
+	if n.User != "" && n.Password != "" {
+		req.SetBasicAuth(n.User, n.Password)
+	}
+	return req, nil
+}
+
+func (n *Ntfy) Notify(ctx context.Context, notif *Notification) error {
+	req, err := n.newRequest(ctx, http.MethodPost, n.topicURI(notif), strings.NewReader(notif.Body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", notif.Title)
+	if notif.Click != "" {
+		req.Header.Set("Click", notif.Click)
+	} else {
+		req.Header.Set("Click", "dismiss")
+	}
+	if len(notif.Tags) > 0 {
+		req.Header.Set("Tags", strings.Join(notif.Tags, ","))
+	}
+	if notif.Priority != 0 {
+		req.Header.Set("Priority", strconv.Itoa(notif.Priority))
+	}
+	if len(notif.Actions) > 0 {
+		actions := make([]string, 0, len(notif.Actions))
+		for _, a := range notif.Actions {
+			actions = append(actions, fmt.Sprintf("http, %s, %s, method=POST, clear=true", a.Label, a.URL))
+		}
+		req.Header.Set("Actions", strings.Join(actions, "; "))
+	}
+	if notif.ID != "" {
+		// Allows the notification to be cleared or deleted later on
+		req.Header.Set("X-Sequence-ID", notif.ID)
+	}
+	return do(req)
+}
+
+// Clear marks the notification as read, dismissing it on subscribed devices
+func (n *Ntfy) Clear(ctx context.Context, notif *Notification) error {
+	req, err := n.newRequest(ctx, http.MethodPut, n.topicURI(notif)+"/"+notif.ID+"/clear", nil)
+	if err != nil {
+		return err
+	}
+	return do(req)
+}
+
+// Delete deletes the notification from subscribed devices
+func (n *Ntfy) Delete(ctx context.Context, notif *Notification) error {
+	req, err := n.newRequest(ctx, http.MethodDelete, n.topicURI(notif)+"/"+notif.ID, nil)
+	if err != nil {
+		return err
+	}
+	return do(req)
+}
@@ -0,0 +1,220 @@
+package push
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const (
+	// outboxInterval is the interval between two checks for queued
+	// notifications ready to be retried
+	outboxInterval = 15 * time.Second
+	// outboxMinBackoff and outboxMaxBackoff bound the delay between two
+	// delivery attempts
+	outboxMinBackoff = 30 * time.Second
+	outboxMaxBackoff = time.Hour
+)
+
+var outboxBucket = []byte("outbox")
+
+// outboxEntry is a notification waiting to be delivered to a sink
+type outboxEntry struct {
+	Target       string
+	Notification *Notification
+	Message      *Message
+	Created      time.Time
+	Attempts     int
+	NextAttempt  time.Time
+}
+
+// Outbox persists notifications which failed to be delivered and retries
+// them with exponential backoff
+type Outbox struct {
+	db     *bolt.DB
+	maxAge time.Duration
+}
+
+// OpenOutbox opens the outbox stored at path. Notifications which could not
+// be delivered within maxAge are dropped.
+func OpenOutbox(path string, maxAge time.Duration) (*Outbox, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(outboxBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Outbox{db: db, maxAge: maxAge}, nil
+}
+
+func (o *Outbox) Close() error {
+	return o.db.Close()
+}
+
+func backoff(attempts int, err error) time.Duration {
+	d := outboxMaxBackoff
+	if attempts < 8 {
+		d = outboxMinBackoff << (attempts - 1)
+		if d > outboxMaxBackoff {
+			d = outboxMaxBackoff
+		}
+	}
+	if statusErr, ok := err.(*StatusError); ok && statusErr.RetryAfter > d {
+		d = statusErr.RetryAfter
+	}
+	return d
+}
+
+func shouldRetry(err error) bool {
+	statusErr, ok := err.(*StatusError)
+	return !ok || !statusErr.Permanent()
+}
+
+func (o *Outbox) put(id uint64, entry *outboxEntry) error {
+	return o.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(outboxBucket)
+		if id == 0 {
+			var err error
+			if id, err = b.NextSequence(); err != nil {
+				return err
+			}
+		}
+		v, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		k := make([]byte, 8)
+		binary.BigEndian.PutUint64(k, id)
+		return b.Put(k, v)
+	})
+}
+
+func (o *Outbox) delete(id uint64) error {
+	return o.db.Update(func(tx *bolt.Tx) error {
+		k := make([]byte, 8)
+		binary.BigEndian.PutUint64(k, id)
+		return tx.Bucket(outboxBucket).Delete(k)
+	})
+}
+
+// Enqueue queues n for another delivery attempt to s, after the delivery
+// failed with err
+func (o *Outbox) Enqueue(s *Sink, n *Notification, err error) error {
+	now := time.Now()
+	return o.put(0, &outboxEntry{
+		Target:       s.Target,
+		Notification: n,
+		Message:      n.Message,
+		Created:      now,
+		Attempts:     1,
+		NextAttempt:  now.Add(backoff(1, err)),
+	})
+}
+
+// Dispatch sends n to every sink, logging the outcome of each delivery.
+// Failed deliveries are queued for retry, unless the outbox is nil.
+func (o *Outbox) Dispatch(ctx context.Context, sinks []*Sink, n *Notification) {
+	for _, s := range sinks {
+		err := s.Notify(ctx, n)
+		if err == nil {
+			log.Printf("Push event sent to %s for %s", s.Name, n.Account)
+			continue
+		}
+		log.Printf("failed to publish to %s for %s: %v", s.Name, n.Account, err)
+		if o == nil || !shouldRetry(err) {
+			continue
+		}
+		if err := o.Enqueue(s, n, err); err != nil {
+			log.Printf("cannot queue notification for retry: %v", err)
+		} else {
+			log.Printf("notification for %s queued for retry", s.Name)
+		}
+	}
+}
+
+// Flush attempts to deliver the queued notifications whose retry delay has
+// elapsed. If all is set, all queued notifications are attempted.
+func (o *Outbox) Flush(ctx context.Context, all bool) {
+	due := make(map[uint64]*outboxEntry)
+	now := time.Now()
+	err := o.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(outboxBucket).ForEach(func(k, v []byte) error {
+			entry := new(outboxEntry)
+			if err := json.Unmarshal(v, entry); err != nil {
+				log.Printf("cannot read queued notification: %v", err)
+				return nil
+			}
+			if all || !entry.NextAttempt.After(now) {
+				due[binary.BigEndian.Uint64(k)] = entry
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		log.Printf("cannot read outbox: %v", err)
+		return
+	}
+
+	for id, entry := range due {
+		if ctx.Err() != nil {
+			return
+		}
+		o.retry(ctx, id, entry)
+	}
+}
+
+func (o *Outbox) retry(ctx context.Context, id uint64, entry *outboxEntry) {
+	s, err := New(entry.Target)
+	if err != nil {
+		log.Printf("dropping queued notification: %v", err)
+		o.delete(id)
+		return
+	}
+	n := entry.Notification
+	n.Message = entry.Message
+
+	err = s.Notify(ctx, n)
+	if err == nil {
+		log.Printf("Push event sent to %s for %s after %d attempt(s)", s.Name, n.Account, entry.Attempts+1)
+		o.delete(id)
+		return
+	}
+
+	entry.Attempts++
+	log.Printf("failed to publish to %s for %s (attempt %d): %v", s.Name, n.Account, entry.Attempts, err)
+	if !shouldRetry(err) || time.Since(entry.Created) > o.maxAge {
+		log.Printf("dropping notification for %s queued since %v", s.Name, entry.Created.Format(time.RFC3339))
+		o.delete(id)
+		return
+	}
+	entry.NextAttempt = time.Now().Add(backoff(entry.Attempts, err))
+	if err := o.put(id, entry); err != nil {
+		log.Printf("cannot update queued notification: %v", err)
+	}
+}
+
+// Run retries queued notifications until ctx is cancelled
+func (o *Outbox) Run(ctx context.Context) {
+	t := time.NewTicker(outboxInterval)
+	defer t.Stop()
+
+	o.Flush(ctx, false)
+	for {
+		select {
+		case <-t.C:
+			o.Flush(ctx, false)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package push
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	pushoverEmergency = 2
+	// Pushover requires retry to be at least 30 seconds and expire at most
+	// 3 hours
+	defaultPushoverRetry  = time.Minute
+	defaultPushoverExpire = time.Hour
+	minPushoverRetry      = 30 * time.Second
+	maxPushoverExpire     = 3 * time.Hour
+)
+
+// Pushover sends notifications through the Pushover messages API
+type Pushover struct {
+	// URL is the base URL of the API
+	URL string
+	// Token is the application token and User the user or group key
+	Token  string
+	User   string
+	Device string
+	Sound  string
+	// Retry and Expire control how emergency notifications are repeated
+	Retry  time.Duration
+	Expire time.Duration
+}
+
+func newPushover(u *url.URL) (*Pushover, error) {
+	q := u.Query()
+	if u.Host == "" || q.Get("token") == "" || q.Get("user") == "" {
+		return nil, fmt.Errorf("pushover target must be of the form %s://api.pushover.net/?token=apptoken&user=userkey", u.Scheme)
+	}
+	p := &Pushover{
+		URL:    "https://" + u.Host,
+		Token:  q.Get("token"),
+		User:   q.Get("user"),
+		Device: q.Get("device"),
+		Sound:  q.Get("sound"),
+		Retry:  defaultPushoverRetry,
+		Expire: defaultPushoverExpire,
+	}
+	var err error
+	if q.Has("retry") {
+		if p.Retry, err = time.ParseDuration(q.Get("retry")); err != nil {
+			return nil, fmt.Errorf("invalid pushover retry: %v", err)
+		}
+		if p.Retry < minPushoverRetry {
+			p.Retry = minPushoverRetry
+		}
+	}
+	if q.Has("expire") {
+		if p.Expire, err = time.ParseDuration(q.Get("expire")); err != nil {
+			return nil, fmt.Errorf("invalid pushover expire: %v", err)
+		}
+		if p.Expire > maxPushoverExpire {
+			p.Expire = maxPushoverExpire
+		}
+	}
+	return p, nil
+}
+
+// pushoverPriority maps priorities from 1 to 5 to Pushover priorities from
+// -2 to 2, 2 being emergency
+var pushoverPriority = [...]int{1: -2, 2: -1, 3: 0, 4: 1, 5: pushoverEmergency}
+
+// post posts form to path on the API server
+func (p *Pushover) post(ctx context.Context, path string, form url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.URL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return http.DefaultClient.Do(req)
+}
+
+func (p *Pushover) Notify(ctx context.Context, n *Notification) error {
+	form := url.Values{
+		"token":   {p.Token},
+		"user":    {p.User},
+		"title":   {n.Title},
+		"message": {n.Body},
+	}
+	if p.Device != "" {
+		form.Set("device", p.Device)
+	}
+	if p.Sound != "" {
+		form.Set("sound", p.Sound)
+	}
+	if strings.HasPrefix(n.Click, "http://") || strings.HasPrefix(n.Click, "https://") {
+		form.Set("url", n.Click)
+		form.Set("url_title", "Open in Proton Mail")
+	}
+	priority := 0
+	if n.Priority > 0 && n.Priority < len(pushoverPriority) {
+		priority = pushoverPriority[n.Priority]
+	}
+	form.Set("priority", strconv.Itoa(priority))
+	if priority == pushoverEmergency {
+		form.Set("retry", strconv.Itoa(int(p.Retry.Seconds())))
+		form.Set("expire", strconv.Itoa(int(p.Expire.Seconds())))
+	}
+	if n.Message != nil && !n.Message.Time.IsZero() {
+		form.Set("timestamp", strconv.FormatInt(n.Message.Time.Unix(), 10))
+	}
+
+	resp, err := p.post(ctx, "/1/messages.json", form)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return err
+	}
+
+	var result struct {
+		Receipt string `json:"receipt"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err == nil && result.Receipt != "" && n.ID != "" {
+		// Remembered in the history, to stop the alert once the message is
+		// read even after a restart
+		if err := receipts.putReceipt(n.ID, result.Receipt); err != nil {
+			log.Printf("cannot save Pushover receipt: %v", err)
+		}
+	}
+	return nil
+}
+
+// cancel stops repeating the emergency notification n, if it is one
+func (p *Pushover) cancel(ctx context.Context, n *Notification) error {
+	receipt, err := receipts.takeReceipt(n.ID)
+	if err != nil {
+		return err
+	} else if receipt == "" {
+		return nil
+	}
+
+	resp, err := p.post(ctx, "/1/receipts/"+url.PathEscape(receipt)+"/cancel.json", url.Values{"token": {p.Token}})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkStatus(resp)
+}
+
+// Clear stops repeating an emergency notification. Pushover notifications
+// cannot be dismissed remotely.
+func (p *Pushover) Clear(ctx context.Context, n *Notification) error {
+	return p.cancel(ctx, n)
+}
+
+func (p *Pushover) Delete(ctx context.Context, n *Notification) error {
+	return p.cancel(ctx, n)
+}
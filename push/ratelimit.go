@@ -0,0 +1,103 @@
+package push
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// maxInlineRetryAfter is the longest delay requested by a rate-limited
+	// service waited for before retrying, longer delays are left to the
+	// outbox
+	maxInlineRetryAfter = 5 * time.Second
+	// defaultRetryAfter is assumed when a rate-limited service does not
+	// tell how long to wait
+	defaultRetryAfter = time.Second
+)
+
+// rateLimits remembers until when services replying 429 asked not to be
+// called again, indexed by URL
+var rateLimits = struct {
+	sync.Mutex
+	until map[string]time.Time
+}{until: make(map[string]time.Time)}
+
+// retryAfterBody returns the delay given in the JSON body of a 429 reply,
+// as sent by Discord (retry_after) and Telegram (parameters.retry_after),
+// in seconds
+func retryAfterBody(body string) time.Duration {
+	var v struct {
+		RetryAfter float64 `json:"retry_after"`
+		Parameters struct {
+			RetryAfter float64 `json:"retry_after"`
+		} `json:"parameters"`
+	}
+	if err := json.Unmarshal([]byte(body), &v); err != nil {
+		return 0
+	}
+	secs := v.RetryAfter
+	if secs == 0 {
+		secs = v.Parameters.RetryAfter
+	}
+	return time.Duration(secs * float64(time.Second))
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// doRateLimited sends the request built by newRequest to a service rate
+// limiting its clients per URL. While the URL is rate limited, requests
+// fail with a StatusError telling how long to wait, unless the delay is
+// short enough to be waited for.
+func doRateLimited(ctx context.Context, uri string, newRequest func() (*http.Request, error)) error {
+	for attempt := 0; ; attempt++ {
+		rateLimits.Lock()
+		wait := time.Until(rateLimits.until[uri])
+		rateLimits.Unlock()
+		if wait > maxInlineRetryAfter {
+			return &StatusError{
+				Code:       http.StatusTooManyRequests,
+				Message:    "rate limited",
+				RetryAfter: wait,
+			}
+		} else if wait > 0 {
+			if err := sleep(ctx, wait); err != nil {
+				return err
+			}
+		}
+
+		req, err := newRequest()
+		if err != nil {
+			// The URL may hold a token, e.g. for Telegram
+			return hideURL(err)
+		}
+		err = do(req)
+		statusErr, ok := err.(*StatusError)
+		if !ok || statusErr.Code != http.StatusTooManyRequests {
+			return err
+		}
+		if statusErr.RetryAfter <= 0 {
+			statusErr.RetryAfter = retryAfterBody(statusErr.Message)
+		}
+		if statusErr.RetryAfter <= 0 {
+			statusErr.RetryAfter = defaultRetryAfter
+		}
+		rateLimits.Lock()
+		rateLimits.until[uri] = time.Now().Add(statusErr.RetryAfter)
+		rateLimits.Unlock()
+		if attempt > 0 || statusErr.RetryAfter > maxInlineRetryAfter {
+			return err
+		}
+	}
+}
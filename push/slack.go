@@ -0,0 +1,113 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Slack posts notifications as Block Kit messages to a Slack incoming
+// webhook
+type Slack struct {
+	URL string
+}
+
+func newSlack(u *url.URL) (*Slack, error) {
+	target := *u
+	target.Scheme = strings.TrimPrefix(u.Scheme, "slack+")
+	return &Slack{URL: target.String()}, nil
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type slackBlock struct {
+	Type     string        `json:"type"`
+	Text     *slackText    `json:"text,omitempty"`
+	Fields   []*slackText  `json:"fields,omitempty"`
+	Elements []interface{} `json:"elements,omitempty"`
+}
+
+type slackButton struct {
+	Type string     `json:"type"`
+	Text *slackText `json:"text"`
+	URL  string     `json:"url"`
+}
+
+type slackMessage struct {
+	// Text is shown in notifications and clients not supporting blocks
+	Text   string        `json:"text"`
+	Blocks []*slackBlock `json:"blocks"`
+}
+
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+func newSlackMessage(n *Notification) *slackMessage {
+	msg := &slackMessage{
+		Text: slackEscaper.Replace(n.Title + ": " + n.Body),
+		Blocks: []*slackBlock{
+			{Type: "header", Text: &slackText{Type: "plain_text", Text: truncate(n.Title, 150)}},
+		},
+	}
+	body := &slackBlock{
+		Type: "section",
+		Text: &slackText{Type: "mrkdwn", Text: truncate(slackEscaper.Replace(n.Body), 3000)},
+	}
+	msg.Blocks = append(msg.Blocks, body)
+
+	if m := n.Message; m != nil {
+		fields := []struct{ name, value string }{
+			{"From", m.Sender.String()},
+			{"Subject", m.Subject},
+			{"Labels", strings.Join(m.Labels, ", ")},
+		}
+		for _, f := range fields {
+			if f.value != "" {
+				body.Fields = append(body.Fields, &slackText{
+					Type: "mrkdwn",
+					Text: truncate("*"+f.name+"*\n"+slackEscaper.Replace(f.value), 2000),
+				})
+			}
+		}
+		if !m.Time.IsZero() {
+			// Shown in the time zone of the reader
+			date := fmt.Sprintf("<!date^%d^{date_short_pretty} {time}|%s>", m.Time.Unix(), m.Time.UTC().Format("2006-01-02 15:04 UTC"))
+			msg.Blocks = append(msg.Blocks, &slackBlock{
+				Type:     "context",
+				Elements: []interface{}{&slackText{Type: "mrkdwn", Text: date}},
+			})
+		}
+	}
+	if strings.HasPrefix(n.Click, "http://") || strings.HasPrefix(n.Click, "https://") {
+		msg.Blocks = append(msg.Blocks, &slackBlock{
+			Type: "actions",
+			Elements: []interface{}{&slackButton{
+				Type: "button",
+				Text: &slackText{Type: "plain_text", Text: "Open"},
+				URL:  n.Click,
+			}},
+		})
+	}
+	return msg
+}
+
+func (s *Slack) Notify(ctx context.Context, n *Notification) error {
+	b, err := json.Marshal(newSlackMessage(n))
+	if err != nil {
+		return err
+	}
+	return doRateLimited(ctx, s.URL, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
+}
@@ -0,0 +1,110 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Telegram sends notifications to a Telegram chat through a bot
+type Telegram struct {
+	// URL is the base URL of the Bot API server
+	URL    string
+	Token  string
+	ChatID string
+}
+
+func newTelegram(u *url.URL) (*Telegram, error) {
+	chatID := strings.Trim(u.Path, "/")
+	token := u.Query().Get("token")
+	if u.Host == "" || chatID == "" || token == "" {
+		return nil, fmt.Errorf("telegram target must be of the form %s://api.telegram.org/chatid?token=bottoken", u.Scheme)
+	}
+	scheme := "https"
+	if u.Scheme == "telegram+http" {
+		scheme = "http"
+	}
+	return &Telegram{
+		URL:    scheme + "://" + u.Host,
+		Token:  token,
+		ChatID: chatID,
+	}, nil
+}
+
+var markdownV2Escaper = strings.NewReplacer(
+	`\`, `\\`, "_", `\_`, "*", `\*`, "[", `\[`, "]", `\]`, "(", `\(`, ")", `\)`,
+	"~", `\~`, "`", "\\`", ">", `\>`, "#", `\#`, "+", `\+`, "-", `\-`, "=", `\=`,
+	"|", `\|`, "{", `\{`, "}", `\}`, ".", `\.`, "!", `\!`,
+)
+
+// escapeMarkdownV2 escapes s to be shown as is in a MarkdownV2 message
+func escapeMarkdownV2(s string) string {
+	return markdownV2Escaper.Replace(s)
+}
+
+type telegramButton struct {
+	Text string `json:"text"`
+	URL  string `json:"url"`
+}
+
+type telegramMarkup struct {
+	InlineKeyboard [][]*telegramButton `json:"inline_keyboard"`
+}
+
+type telegramMessage struct {
+	ChatID      string          `json:"chat_id"`
+	Text        string          `json:"text"`
+	ParseMode   string          `json:"parse_mode"`
+	ReplyMarkup *telegramMarkup `json:"reply_markup,omitempty"`
+}
+
+func (t *Telegram) newMessage(n *Notification) *telegramMessage {
+	var text strings.Builder
+	text.WriteString("*" + escapeMarkdownV2(n.Title) + "*\n")
+	text.WriteString(escapeMarkdownV2(n.Body))
+	if m := n.Message; m != nil {
+		fields := []struct{ name, value string }{
+			{"From", m.Sender.String()},
+			{"Subject", m.Subject},
+			{"Labels", strings.Join(m.Labels, ", ")},
+		}
+		text.WriteString("\n")
+		for _, f := range fields {
+			if f.value != "" {
+				text.WriteString("\n_" + f.name + ":_ " + escapeMarkdownV2(f.value))
+			}
+		}
+	}
+
+	msg := &telegramMessage{
+		ChatID:    t.ChatID,
+		Text:      text.String(),
+		ParseMode: "MarkdownV2",
+	}
+	if strings.HasPrefix(n.Click, "http://") || strings.HasPrefix(n.Click, "https://") {
+		msg.ReplyMarkup = &telegramMarkup{
+			InlineKeyboard: [][]*telegramButton{{{Text: "Open in Proton Mail", URL: n.Click}}},
+		}
+	}
+	return msg
+}
+
+func (t *Telegram) Notify(ctx context.Context, n *Notification) error {
+	b, err := json.Marshal(t.newMessage(n))
+	if err != nil {
+		return err
+	}
+	uri := t.URL + "/bot" + t.Token + "/sendMessage"
+	return doRateLimited(ctx, uri, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, uri, bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
+}
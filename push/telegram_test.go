@@ -0,0 +1,37 @@
+package push
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTelegramErrorHidesToken(t *testing.T) {
+	const token = "123456:SECRETTOKEN"
+
+	// A server closing connections without replying
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	}))
+	defer srv.Close()
+
+	s, err := New("telegram+http://" + strings.TrimPrefix(srv.URL, "http://") + "/42?token=" + token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(s.Name, "SECRETTOKEN") {
+		t.Errorf("sink name %q holds the bot token", s.Name)
+	}
+	err = s.Notify(context.Background(), &Notification{Title: "title", Body: "body"})
+	if err == nil {
+		t.Fatal("expected a network error")
+	}
+	if strings.Contains(err.Error(), "SECRETTOKEN") {
+		t.Errorf("error %q holds the bot token", err)
+	}
+}
@@ -0,0 +1,130 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultSignatureHeader carries the HMAC-SHA256 signature of webhook
+	// requests, as "sha256=" followed by the hex-encoded signature
+	defaultSignatureHeader = "X-Hydroxide-Signature"
+	// timestampHeader carries the Unix time webhook requests were signed at
+	timestampHeader = "X-Hydroxide-Timestamp"
+)
+
+// Webhook posts new message events as JSON to an HTTP endpoint. If a secret
+// is set, requests are signed with HMAC-SHA256.
+type Webhook struct {
+	URL    string
+	Secret string
+	// SignatureHeader is the header carrying the signature
+	SignatureHeader string
+}
+
+func newWebhook(u *url.URL) (*Webhook, error) {
+	target := *u
+	target.Scheme = strings.TrimPrefix(u.Scheme, "webhook+")
+
+	// The signature parameters are not sent to the endpoint
+	q := target.Query()
+	w := &Webhook{
+		Secret:          q.Get("secret"),
+		SignatureHeader: q.Get("signatureHeader"),
+	}
+	if w.SignatureHeader == "" {
+		w.SignatureHeader = defaultSignatureHeader
+	}
+	if q.Has("secret") || q.Has("signatureHeader") {
+		q.Del("secret")
+		q.Del("signatureHeader")
+		target.RawQuery = q.Encode()
+	}
+	w.URL = target.String()
+	return w, nil
+}
+
+// WebhookEvent is the JSON document posted by Webhook. Its fields are
+// documented in the README and must be kept stable.
+type WebhookEvent struct {
+	// Type is always "message.received"
+	Type string `json:"type"`
+	// EventID is the ID of the Proton event the message was received in
+	EventID    string    `json:"eventId,omitempty"`
+	MessageID  string    `json:"messageId"`
+	Account    string    `json:"account"`
+	Sender     Address   `json:"sender"`
+	Recipients []Address `json:"recipients"`
+	// Address is the Proton address the message was received at
+	Address     string    `json:"address"`
+	Subject     string    `json:"subject"`
+	Labels      []string  `json:"labels"`
+	Time        time.Time `json:"time"`
+	Attachments int       `json:"attachments"`
+	Starred     bool      `json:"starred"`
+	// Notification is the notification sent to the other sinks, without
+	// its action buttons
+	Notification *Notification `json:"notification"`
+}
+
+func newWebhookEvent(n *Notification) *WebhookEvent {
+	// The action URLs carry tokens acting on the message, only meant for
+	// the push services showing the buttons
+	notification := *n
+	notification.Actions = nil
+	ev := &WebhookEvent{
+		Type:         "message.received",
+		MessageID:    n.MessageID,
+		Account:      n.Account,
+		Notification: &notification,
+	}
+	if m := n.Message; m != nil {
+		ev.EventID = m.EventID
+		ev.Sender = m.Sender
+		ev.Recipients = m.Recipients
+		ev.Address = m.Address
+		ev.Subject = m.Subject
+		ev.Labels = m.Labels
+		ev.Time = m.Time
+		ev.Attachments = m.Attachments
+		ev.Starred = m.Starred
+	}
+	return ev
+}
+
+// sign returns the signature of body sent at timestamp
+func (w *Webhook) sign(timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(w.Secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (w *Webhook) Notify(ctx context.Context, n *Notification) error {
+	b, err := json.Marshal(newWebhookEvent(n))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.Secret != "" {
+		// Signed at each attempt, so that retries have a fresh timestamp
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(timestampHeader, timestamp)
+		req.Header.Set(w.SignatureHeader, w.sign(timestamp, b))
+	}
+	return do(req)
+}
@@ -0,0 +1,284 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	defaultWebPushTTL     = 24 * time.Hour
+	defaultVAPIDSubject   = "https://github.com/0ranki/hydroxide-push"
+	vapidTokenLifetime    = 12 * time.Hour
+	webPushRecordSize     = 4096
+	webPushMaxPayloadSize = 3993
+)
+
+// vapidKey signs the VAPID tokens of Web Push requests, see LoadVAPIDKey
+var vapidKey struct {
+	sync.Mutex
+	key *ecdsa.PrivateKey
+}
+
+// LoadVAPIDKey loads the key used to sign Web Push requests from path,
+// generating it if the file does not exist. It returns the public key, as
+// expected by Web Push subscribers.
+func LoadVAPIDKey(path string) (string, error) {
+	vapidKey.Lock()
+	defer vapidKey.Unlock()
+
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		vapidKey.key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate VAPID key: %v", err)
+		}
+		der, err := x509.MarshalECPrivateKey(vapidKey.key)
+		if err != nil {
+			return "", err
+		}
+		b = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+		if err := os.WriteFile(path, b, 0600); err != nil {
+			return "", err
+		}
+	} else if err != nil {
+		return "", err
+	} else {
+		block, _ := pem.Decode(b)
+		if block == nil {
+			return "", fmt.Errorf("invalid VAPID key file %s", path)
+		}
+		if vapidKey.key, err = x509.ParseECPrivateKey(block.Bytes); err != nil {
+			return "", fmt.Errorf("invalid VAPID key: %v", err)
+		}
+	}
+
+	pub, err := vapidKey.key.PublicKey.ECDH()
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(pub.Bytes()), nil
+}
+
+// WebPush delivers notifications to a Web Push subscription (RFC 8030), with
+// payloads encrypted to the subscriber (RFC 8291) and VAPID authentication
+// (RFC 8292)
+type WebPush struct {
+	// Endpoint is the push endpoint of the subscription
+	Endpoint string
+	// P256dh is the public key of the subscriber
+	P256dh *ecdh.PublicKey
+	// Auth is the authentication secret of the subscriber
+	Auth []byte
+	// Subject is a contact URL sent to the push service
+	Subject string
+	// TTL is how long the push service keeps undelivered messages
+	TTL time.Duration
+}
+
+// decodeKey decodes a subscription key, encoded in URL-safe base64 with or
+// without padding
+func decodeKey(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+func newWebPush(u *url.URL) (*WebPush, error) {
+	q := u.Query()
+	if u.Host == "" || !q.Has("p256dh") || !q.Has("auth") {
+		return nil, fmt.Errorf("webpush target must be of the form %s://host/path?p256dh=key&auth=secret", u.Scheme)
+	}
+
+	b, err := decodeKey(q.Get("p256dh"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid webpush p256dh: %v", err)
+	}
+	w := &WebPush{Subject: defaultVAPIDSubject, TTL: defaultWebPushTTL}
+	if w.P256dh, err = ecdh.P256().NewPublicKey(b); err != nil {
+		return nil, fmt.Errorf("invalid webpush p256dh: %v", err)
+	}
+	if w.Auth, err = decodeKey(q.Get("auth")); err != nil || len(w.Auth) != 16 {
+		return nil, errors.New("invalid webpush auth secret")
+	}
+	if q.Has("subject") {
+		w.Subject = q.Get("subject")
+	}
+	if q.Has("ttl") {
+		if w.TTL, err = time.ParseDuration(q.Get("ttl")); err != nil {
+			return nil, fmt.Errorf("invalid webpush ttl: %v", err)
+		}
+	}
+
+	// The subscription parameters are not sent to the push service
+	endpoint := *u
+	endpoint.Scheme = strings.TrimPrefix(u.Scheme, "webpush+")
+	for _, k := range []string{"p256dh", "auth", "subject", "ttl"} {
+		q.Del(k)
+	}
+	endpoint.RawQuery = q.Encode()
+	w.Endpoint = endpoint.String()
+	return w, nil
+}
+
+// encrypt encrypts payload to the subscriber, as a single aes128gcm record
+// (RFC 8188) keyed as specified by RFC 8291
+func (w *WebPush) encrypt(payload []byte) ([]byte, error) {
+	asKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return w.encryptWith(payload, asKey, salt)
+}
+
+// encryptWith encrypts payload with the ephemeral key asKey and salt
+func (w *WebPush) encryptWith(payload []byte, asKey *ecdh.PrivateKey, salt []byte) ([]byte, error) {
+	ecdhSecret, err := asKey.ECDH(w.P256dh)
+	if err != nil {
+		return nil, err
+	}
+	uaPublic := w.P256dh.Bytes()
+	asPublic := asKey.PublicKey().Bytes()
+
+	keyInfo := append([]byte("WebPush: info\x00"), uaPublic...)
+	keyInfo = append(keyInfo, asPublic...)
+	ikm := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, ecdhSecret, w.Auth, keyInfo), ikm); err != nil {
+		return nil, err
+	}
+
+	prk := hkdf.Extract(sha256.New, ikm, salt)
+	cek := make([]byte, 16)
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, prk, []byte("Content-Encoding: aes128gcm\x00")), cek); err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, 12)
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, prk, []byte("Content-Encoding: nonce\x00")), nonce); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// Header: salt, record size, key ID length and key ID
+	var buf bytes.Buffer
+	buf.Write(salt)
+	binary.Write(&buf, binary.BigEndian, uint32(webPushRecordSize))
+	buf.WriteByte(byte(len(asPublic)))
+	buf.Write(asPublic)
+	// The last record is delimited with 0x02, without padding
+	plaintext := append(payload[:len(payload):len(payload)], 0x02)
+	return gcm.Seal(buf.Bytes(), nonce, plaintext, nil), nil
+}
+
+// vapidAuthorization returns the Authorization header of a request to
+// endpoint
+func (w *WebPush) vapidAuthorization() (string, error) {
+	vapidKey.Lock()
+	key := vapidKey.key
+	vapidKey.Unlock()
+	if key == nil {
+		return "", errors.New("VAPID key not loaded")
+	}
+
+	u, err := url.Parse(w.Endpoint)
+	if err != nil {
+		return "", err
+	}
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"typ":"JWT","alg":"ES256"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": time.Now().Add(vapidTokenLifetime).Unix(),
+		"sub": w.Subject,
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	hash := sha256.Sum256([]byte(unsigned))
+	r, s, err := ecdsa.Sign(rand.Reader, key, hash[:])
+	if err != nil {
+		return "", err
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+
+	pub, err := key.PublicKey.ECDH()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("vapid t=%s.%s, k=%s", unsigned, base64.RawURLEncoding.EncodeToString(sig),
+		base64.RawURLEncoding.EncodeToString(pub.Bytes())), nil
+}
+
+// webPushUrgency maps priorities from 1 to 5 to the Urgency header
+var webPushUrgency = [...]string{1: "very-low", 2: "low", 3: "normal", 4: "high", 5: "high"}
+
+// Notify sends the notification as JSON, encrypted to the subscriber
+func (w *WebPush) Notify(ctx context.Context, n *Notification) error {
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	if len(payload) > webPushMaxPayloadSize {
+		return fmt.Errorf("webpush payload too large: %d bytes", len(payload))
+	}
+	body, err := w.encrypt(payload)
+	if err != nil {
+		return err
+	}
+	authorization, err := w.vapidAuthorization()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("TTL", strconv.Itoa(int(w.TTL.Seconds())))
+	req.Header.Set("Authorization", authorization)
+	if n.Priority > 0 && n.Priority < len(webPushUrgency) {
+		req.Header.Set("Urgency", webPushUrgency[n.Priority])
+	}
+	if n.ID != "" {
+		// Replaces a retried message still queued by the push service
+		req.Header.Set("Topic", n.ID)
+	}
+	return do(req)
+}
@@ -0,0 +1,200 @@
+package push
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// Test vector of RFC 8291, Appendix A
+const (
+	rfc8291Plaintext  = "V2hlbiBJIGdyb3cgdXAsIEkgd2FudCB0byBiZSBhIHdhdGVybWVsb24"
+	rfc8291ASPrivate  = "yfWPiYE-n46HLnH0KqZOF1fJJU3MYrct3AELtAQ-oRw"
+	rfc8291UAPublic   = "BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4"
+	rfc8291UAPrivate  = "q1dXpw3UpT5VOmu_cf_v6ih07Aems3njxI-JWgLcM94"
+	rfc8291Salt       = "DGv6ra1nlYgDCS1FRnbzlw"
+	rfc8291AuthSecret = "BTBZMqHH6r4Tts7J_aSIgg"
+	rfc8291Message    = "DGv6ra1nlYgDCS1FRnbzlwAAEABBBP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A_yl95bQpu6cVPTpK4Mqgkf1CXztLVBSt2Ks3oZwbuwXPXLWyouBWLVWGNWQexSgSxsj_Qulcy4a-fN"
+)
+
+func mustDecode(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// decryptWebPush decrypts a single record aes128gcm message as a user agent
+func decryptWebPush(t *testing.T, message []byte, uaKey *ecdh.PrivateKey, auth []byte) []byte {
+	t.Helper()
+	if len(message) < 21 {
+		t.Fatalf("message too short: %d bytes", len(message))
+	}
+	salt := message[:16]
+	rs := binary.BigEndian.Uint32(message[16:20])
+	idlen := int(message[20])
+	asPublic, err := ecdh.P256().NewPublicKey(message[21 : 21+idlen])
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext := message[21+idlen:]
+	if uint32(len(ciphertext)) > rs {
+		t.Fatalf("record larger than the record size %d", rs)
+	}
+
+	ecdhSecret, err := uaKey.ECDH(asPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyInfo := append([]byte("WebPush: info\x00"), uaKey.PublicKey().Bytes()...)
+	keyInfo = append(keyInfo, asPublic.Bytes()...)
+	ikm := make([]byte, 32)
+	io.ReadFull(hkdf.New(sha256.New, ecdhSecret, auth, keyInfo), ikm)
+	cek := make([]byte, 16)
+	io.ReadFull(hkdf.New(sha256.New, ikm, salt, []byte("Content-Encoding: aes128gcm\x00")), cek)
+	nonce := make([]byte, 12)
+	io.ReadFull(hkdf.New(sha256.New, ikm, salt, []byte("Content-Encoding: nonce\x00")), nonce)
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		t.Fatalf("cannot decrypt: %v", err)
+	}
+	// Remove the padding and the last record delimiter
+	plaintext = bytes.TrimRight(plaintext, "\x00")
+	if len(plaintext) == 0 || plaintext[len(plaintext)-1] != 0x02 {
+		t.Fatal("missing last record delimiter")
+	}
+	return plaintext[:len(plaintext)-1]
+}
+
+func TestWebPushEncryptRFC8291(t *testing.T) {
+	uaPublic, err := ecdh.P256().NewPublicKey(mustDecode(t, rfc8291UAPublic))
+	if err != nil {
+		t.Fatal(err)
+	}
+	asKey, err := ecdh.P256().NewPrivateKey(mustDecode(t, rfc8291ASPrivate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &WebPush{P256dh: uaPublic, Auth: mustDecode(t, rfc8291AuthSecret)}
+
+	message, err := w.encryptWith(mustDecode(t, rfc8291Plaintext), asKey, mustDecode(t, rfc8291Salt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := base64.RawURLEncoding.EncodeToString(message); got != rfc8291Message {
+		t.Errorf("encrypted message:\ngot  %s\nwant %s", got, rfc8291Message)
+	}
+}
+
+func TestWebPushEncryptRoundTrip(t *testing.T) {
+	uaKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := make([]byte, 16)
+	rand.Read(auth)
+	w := &WebPush{P256dh: uaKey.PublicKey(), Auth: auth}
+
+	payload := []byte(`{"title":"ProtonMail","body":"New message received"}`)
+	message, err := w.encrypt(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := decryptWebPush(t, message, uaKey, auth); !bytes.Equal(got, payload) {
+		t.Errorf("decrypted payload: got %q, want %q", got, payload)
+	}
+
+	// The user agent private key of the RFC decrypts the RFC message
+	rfcKey, err := ecdh.P256().NewPrivateKey(mustDecode(t, rfc8291UAPrivate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := decryptWebPush(t, mustDecode(t, rfc8291Message), rfcKey, mustDecode(t, rfc8291AuthSecret))
+	if want := mustDecode(t, rfc8291Plaintext); !bytes.Equal(got, want) {
+		t.Errorf("decrypted RFC message: got %q, want %q", got, want)
+	}
+}
+
+func TestVAPIDAuthorization(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vapidKey.Lock()
+	vapidKey.key = key
+	vapidKey.Unlock()
+
+	w := &WebPush{Endpoint: "https://push.example.com/send/abc?x=1", Subject: "mailto:admin@example.com"}
+	auth, err := w.vapidAuthorization()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, k, ok := strings.Cut(strings.TrimPrefix(auth, "vapid t="), ", k=")
+	if !strings.HasPrefix(auth, "vapid t=") || !ok {
+		t.Fatalf("invalid Authorization header %q", auth)
+	}
+	pub, _ := key.PublicKey.ECDH()
+	if k != base64.RawURLEncoding.EncodeToString(pub.Bytes()) {
+		t.Errorf("k is %q, not the VAPID public key", k)
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("invalid JWT %q", token)
+	}
+	var header struct{ Typ, Alg string }
+	if err := json.Unmarshal(mustDecode(t, parts[0]), &header); err != nil || header.Alg != "ES256" {
+		t.Errorf("invalid JWT header %q", mustDecode(t, parts[0]))
+	}
+	var claims struct {
+		Aud string `json:"aud"`
+		Exp int64  `json:"exp"`
+		Sub string `json:"sub"`
+	}
+	if err := json.Unmarshal(mustDecode(t, parts[1]), &claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims.Aud != "https://push.example.com" || claims.Sub != w.Subject {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+	if exp := time.Unix(claims.Exp, 0); exp.Before(time.Now()) || exp.After(time.Now().Add(24*time.Hour)) {
+		t.Errorf("expiration %v must be within 24 hours", exp)
+	}
+
+	sig := mustDecode(t, parts[2])
+	if len(sig) != 64 {
+		t.Fatalf("signature is %d bytes, not 64", len(sig))
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+	if !ecdsa.Verify(&key.PublicKey, hash[:], r, s) {
+		t.Error("invalid JWT signature")
+	}
+}